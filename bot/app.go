@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/sirupsen/logrus"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// Deps are the external services the app works with.
+type Deps struct {
+	Log       *logrus.Logger
+	Storage   *firestore.Client
+	Delimobil DelimobilClient
+	// Telegram requests are counted by the transport of the bot client, so the metrics come with it
+	Metrics *Metrics
+	// The bot is created by the app to register handlers, so only its settings are given
+	BotSettings tele.Settings
+}
+
+// App is the bot with everything it depends on.
+// Apps share nothing but the storage they are given, so several of them may run in one process, e.g. in tests.
+type App struct {
+	*Menus
+	config    AppConfig
+	log       *logrus.Logger
+	ctx       context.Context
+	client    *firestore.Client
+	delimobil *ResilientDelimobil
+	breakers  *CircuitBreakers
+	metrics   *Metrics
+	bot       *tele.Bot
+	outbox    *Outbox
+	poller    *GracefulPoller
+	// Monitoring server if it's configured
+	monitoring *http.Server
+	dialogs    map[string]*Dialog
+	inFlight   sync.WaitGroup
+	startedAt  time.Time
+	// Unix time of the last notifier tick start and finish
+	notifierTickStarted, notifierTickFinished int64
+}
+
+// NewLogger configures logging for the environment.
+func NewLogger(environment string) *logrus.Logger {
+	logger := logrus.New()
+	if environment == "prod" {
+		logger.SetLevel(logrus.WarnLevel)
+	} else {
+		logger.SetLevel(logrus.TraceLevel)
+		logger.SetReportCaller(true)
+		logger.Info("Using 'test' environment")
+	}
+	return logger
+}
+
+// DefaultDeps connects to Firestore, Delimobil and Telegram as configured.
+func DefaultDeps(ctx context.Context, config AppConfig) (Deps, error) {
+	logger := NewLogger(config.Environment)
+	storage, err := firestore.NewClient(ctx, config.ProjectID)
+	if err != nil {
+		return Deps{}, err
+	}
+	metrics := NewMetrics()
+	client := &http.Client{Timeout: time.Minute, Transport: &metricsTransport{next: http.DefaultTransport, sent: metrics.messagesSent}}
+	return Deps{
+		Log:       logger,
+		Storage:   storage,
+		Delimobil: RealDelimobil{},
+		Metrics:   metrics,
+		BotSettings: tele.Settings{
+			Token:  config.TelegramToken,
+			Poller: NewPoller(config.Webhook, client, logger),
+			Client: client,
+		},
+	}, nil
+}
+
+// NewApp creates the bot and registers all its handlers and dialogs.
+func NewApp(ctx context.Context, config AppConfig, deps Deps) (*App, error) {
+	app := &App{
+		Menus:     NewMenus(),
+		config:    config,
+		log:       deps.Log,
+		ctx:       ctx,
+		client:    deps.Storage,
+		breakers:  NewCircuitBreakers(deps.Log),
+		metrics:   deps.Metrics,
+		dialogs:   make(map[string]*Dialog),
+		startedAt: time.Now(),
+	}
+	if app.metrics == nil {
+		app.metrics = NewMetrics()
+	}
+	app.delimobil = NewResilientDelimobil(deps.Delimobil, app.breakers, app.metrics, app.log)
+	settings := deps.BotSettings
+	// Updates are handled in goroutines started by serve
+	settings.Synchronous = true
+	b, err := tele.NewBot(settings)
+	if err != nil {
+		return nil, err
+	}
+	// The bot falls back to the long poller if none is set
+	app.poller = NewGracefulPoller(b.Poller)
+	b.Poller = app.poller
+	app.bot = b
+	app.outbox = NewOutbox(app, b)
+	app.registerHandlers()
+	return app, nil
+}
+
+func (app *App) notifiers() []Notifier {
+	return []Notifier{
+		{"balance", app.NotifyAboutBalanceChange},
+		{"limits", app.NotifyAboutSpendingLimits},
+		{"rides", app.NotifyAboutNewRides},
+		{"invoices", app.NotifyAboutUnpaidInvoices},
+		{"documents", app.EmailNewClosingDocuments},
+	}
+}
+
+// Run serves updates and notifies users until ctx is done, then shuts down gracefully.
+// The error is returned if the bot can't start receiving updates.
+func (app *App) Run(ctx context.Context) error {
+	if err := app.poller.Register(app.bot); err != nil {
+		return err
+	}
+	app.StartMonitoring(app.config.Monitoring)
+	go app.outbox.Run(ctx)
+
+	app.log.Trace("Starting balance change notifyer...")
+	ticker := time.NewTicker(time.Duration(app.config.CheckDelay) * time.Second)
+	defer ticker.Stop()
+	notifierDone := make(chan struct{})
+	go func() {
+		defer close(notifierDone)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				app.NotifierTick(app.outbox, app.notifiers())
+			}
+		}
+	}()
+
+	app.log.Trace("Starting bot...")
+	app.serve(ctx)
+	app.Shutdown(notifierDone, app.outbox.done)
+	return nil
+}
@@ -0,0 +1,140 @@
+package bot
+
+import (
+	"sort"
+	"strconv"
+
+	deli "github.com/fuksman/delimobil"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// Document types available in the archive with their titles.
+var documentTypes = []struct{ Type, Title string }{
+	{"invoice", "Счета"},
+	{"upd", "УПД"},
+	{"rentsDetail", "Детализации"},
+}
+
+func documentTypeTitle(fileType string) string {
+	for _, documentType := range documentTypes {
+		if documentType.Type == fileType {
+			return documentType.Title
+		}
+	}
+	return fileType
+}
+
+// filesByType returns the company documents of the type, the newest first.
+func (app *App) filesByType(company *Company, fileType string) ([]deli.FileInfo, error) {
+	if err := company.SetFiles(); err != nil {
+		app.log.WithField("companyId", company.Id).Warn(err)
+		return nil, err
+	}
+	var files []deli.FileInfo
+	for _, file := range company.Files {
+		if file.Type == fileType {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Date.After(files[j].Date)
+	})
+	return files, nil
+}
+
+func (app *App) DocumentTypesMenu() *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	rows := make([]tele.Row, 0, len(documentTypes))
+	for _, documentType := range documentTypes {
+		rows = append(rows, menu.Row(menu.Data(documentType.Title, app.btnDocumentType.Unique, documentType.Type)))
+	}
+	menu.Inline(rows...)
+	return menu
+}
+
+func (app *App) SendDocumentTypes(tlg *tele.Context) error {
+	return (*tlg).EditOrSend("Какие нужны документы?", app.DocumentTypesMenu())
+}
+
+// SendDocumentMonths lists months having documents of the type.
+func (app *App) SendDocumentMonths(tlg *tele.Context, fileType string) error {
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	files, err := app.filesByType(company, fileType)
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if len(files) == 0 {
+		return (*tlg).EditOrSend(documentTypeTitle(fileType)+": документов нет", app.DocumentTypesMenu())
+	}
+
+	monthsMenu := &tele.ReplyMarkup{}
+	var (
+		rows []tele.Row
+		row  []tele.Btn
+		seen = make(map[string]bool)
+	)
+	for _, file := range files {
+		month := file.Date.Format("2006-01")
+		if seen[month] {
+			continue
+		}
+		seen[month] = true
+		row = append(row, monthsMenu.Data(file.Date.Format("01.2006"), app.btnDocumentMonth.Unique, fileType, month))
+		if len(row) == 3 {
+			rows = append(rows, monthsMenu.Row(row...))
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, monthsMenu.Row(row...))
+	}
+	rows = append(rows, monthsMenu.Row(app.btnDocumentTypes))
+	monthsMenu.Inline(rows...)
+	return (*tlg).EditOrSend(documentTypeTitle(fileType)+": за какой месяц?", monthsMenu)
+}
+
+// SendDocumentList lists documents of the type for the month.
+func (app *App) SendDocumentList(tlg *tele.Context, fileType, month string) error {
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	files, err := app.filesByType(company, fileType)
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+
+	listMenu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for _, file := range files {
+		if file.Date.Format("2006-01") != month {
+			continue
+		}
+		rows = append(rows, listMenu.Row(listMenu.Data(file.Date.Format("02.01.2006")+" "+file.FileName, app.btnDocumentFile.Unique, strconv.Itoa(file.Id))))
+	}
+	rows = append(rows, listMenu.Row(listMenu.Data("« Назад", app.btnDocumentType.Unique, fileType)))
+	listMenu.Inline(rows...)
+	return (*tlg).EditOrSend(documentTypeTitle(fileType)+" за "+month+":", listMenu)
+}
+
+func (app *App) SendArchiveDocument(tlg *tele.Context, id int) error {
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+
+	userLogger := app.log.WithField("userId", user.Id)
+	userLogger.Trace("Retrieving document " + strconv.Itoa(id) + "...")
+	err = app.SendCachedDocument(tlg, strconv.Itoa(company.Id)+"-file-"+strconv.Itoa(id), archiveCacheTTL, func() (*deli.File, error) {
+		return company.FileById(id)
+	}, menu)
+	if err != nil {
+		userLogger.Warn("Can't retrieve document.")
+		return (*tlg).Send(err.Error(), menu)
+	}
+	userLogger.Info("Recieved document.")
+	return nil
+}
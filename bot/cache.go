@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	deli "github.com/fuksman/delimobil"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+const (
+	// Archive documents never change, so they are fetched from Delimobil only once in a while.
+	archiveCacheTTL = 30 * 24 * time.Hour
+	// Invoices are created in the personal account of Delimobil too, so the last one is checked more often.
+	lastInvoiceCacheTTL = 5 * time.Minute
+)
+
+// CachedDocument is a document already uploaded to Telegram, keyed by the hash of its content.
+type CachedDocument struct {
+	Hash     string
+	FileName string
+	MIME     string
+	FileId   string
+}
+
+// DocumentRef points a request like "last upd of the company" to the cached document it was served with.
+type DocumentRef struct {
+	Key       string
+	Hash      string
+	FetchedAt time.Time
+}
+
+func (app *App) SaveCachedDocument(ctx context.Context, document *CachedDocument) error {
+	documentLogger := app.log.WithField("hash", document.Hash)
+	if err := app.saveGob(ctx, app.collection("documentCache").Doc(document.Hash), document, nil); err != nil {
+		documentLogger.Warn(err)
+		return err
+	}
+	documentLogger.Trace("Cached document")
+	return nil
+}
+
+func (app *App) LoadCachedDocument(ctx context.Context, hash string) (document *CachedDocument, err error) {
+	reqCtx, cancel := app.storageContext(ctx)
+	documentDoc, err := app.collection("documentCache").Doc(hash).Get(reqCtx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	document = &CachedDocument{}
+	return document, decodeGob(documentDoc, document)
+}
+
+func (app *App) SaveDocumentRef(ctx context.Context, ref *DocumentRef) error {
+	if err := app.saveGob(ctx, app.collection("documentRefs").Doc(ref.Key), ref, nil); err != nil {
+		app.log.WithField("documentKey", ref.Key).Warn(err)
+		return err
+	}
+	return nil
+}
+
+func (app *App) LoadDocumentRef(ctx context.Context, key string) (ref *DocumentRef, err error) {
+	reqCtx, cancel := app.storageContext(ctx)
+	refDoc, err := app.collection("documentRefs").Doc(key).Get(reqCtx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	ref = &DocumentRef{}
+	return ref, decodeGob(refDoc, ref)
+}
+
+func (app *App) RemoveDocumentRef(ctx context.Context, key string) error {
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	if _, err := app.collection("documentRefs").Doc(key).Delete(reqCtx); err != nil {
+		app.log.WithField("documentKey", key).Warn(err)
+		return err
+	}
+	return nil
+}
+
+func (app *App) documentCacheTTL() time.Duration {
+	return time.Duration(app.config.DocumentCacheTTL) * time.Second
+}
+
+func (app *App) lastInvoiceCacheTTL() time.Duration {
+	if ttl := app.documentCacheTTL(); ttl < lastInvoiceCacheTTL {
+		return ttl
+	}
+	return lastInvoiceCacheTTL
+}
+
+// lastInvoiceKey is the key of the last invoice of the company, it's removed when the bot creates a new one.
+func lastInvoiceKey(companyId int) string {
+	return strconv.Itoa(companyId) + "-last-invoice"
+}
+
+func (document *CachedDocument) Document() *tele.Document {
+	return &tele.Document{File: tele.File{FileID: document.FileId}, FileName: document.FileName, MIME: document.MIME}
+}
+
+// SendCachedDocument sends the document requested by the key.
+// Within ttl since the last fetch it is sent by Telegram file id without calling Delimobil at all,
+// otherwise it is fetched and uploaded only if its content was never uploaded before.
+func (app *App) SendCachedDocument(tlg *tele.Context, key string, ttl time.Duration, fetch func() (*deli.File, error), opts ...interface{}) error {
+	ctx := UpdateContext(*tlg)
+	documentLogger := app.log.WithField("documentKey", key)
+	if ref, err := app.LoadDocumentRef(ctx, key); err == nil && time.Since(ref.FetchedAt) < ttl {
+		if document, err := app.LoadCachedDocument(ctx, ref.Hash); err == nil {
+			documentLogger.Trace("Sending document from cache...")
+			if err := (*tlg).Send(document.Document(), opts...); err == nil {
+				return nil
+			}
+			documentLogger.Warn("Can't send cached document, fetching it again.")
+		}
+	}
+
+	file, err := fetch()
+	if err != nil {
+		return err
+	}
+	attachment, err := ReadAttachment(file)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(attachment.Data)
+	hash := hex.EncodeToString(sum[:])
+
+	document, err := app.LoadCachedDocument(ctx, hash)
+	if err != nil || document.FileId == "" {
+		document = &CachedDocument{Hash: hash, FileName: file.FileName, MIME: file.MIME}
+		doc := &tele.Document{File: tele.FromReader(bytes.NewReader(attachment.Data)), FileName: file.FileName, MIME: file.MIME}
+		msg, err := (*tlg).Bot().Send((*tlg).Recipient(), doc, opts...)
+		if err != nil {
+			return err
+		}
+		if msg.Document != nil {
+			document.FileId = msg.Document.FileID
+			app.SaveCachedDocument(ctx, document)
+		}
+	} else if err := (*tlg).Send(document.Document(), opts...); err != nil {
+		return err
+	}
+
+	ref := &DocumentRef{Key: key, Hash: hash, FetchedAt: time.Now()}
+	app.SaveDocumentRef(ctx, ref)
+	return nil
+}
@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLastInvoiceAfterNewOne(t *testing.T) {
+	h := newTestHarness(t)
+	addAcme(h)
+	signIn(t, h)
+	h.Delimobil.AddFile(1, "invoice", "invoice-old.pdf", time.Now().Add(-time.Hour), []byte("Старый счёт"))
+
+	h.SendText(adminId, "Последний счёт")
+	h.SendText(adminId, "Новый счёт")
+	if err := h.Press(adminId, "Другая сумма"); err != nil {
+		t.Fatal(err)
+	}
+	h.SendText(adminId, "5000")
+	if err := h.Press(adminId, "Создать"); err != nil {
+		t.Fatal(err)
+	}
+	h.SendText(adminId, "Последний счёт")
+
+	var documents []string
+	for _, sent := range h.Telegram.Sent(adminId) {
+		if sent.Document != "" {
+			documents = append(documents, sent.Document)
+		}
+	}
+	if len(documents) != 3 || documents[0] != "invoice-old.pdf" || documents[2] != documents[1] {
+		t.Errorf("documents = %q, want the old invoice and then the new one twice", documents)
+	}
+}
+
+func TestLastInvoiceCacheTTL(t *testing.T) {
+	app := newOfflineApp(t, newFakeAcme())
+	app.config.DocumentCacheTTL = defaultDocumentCacheTTL
+	if ttl := app.lastInvoiceCacheTTL(); ttl != lastInvoiceCacheTTL {
+		t.Errorf("ttl = %v, want %v", ttl, lastInvoiceCacheTTL)
+	}
+	app.config.DocumentCacheTTL = 60
+	if ttl := app.lastInvoiceCacheTTL(); ttl != time.Minute {
+		t.Errorf("ttl = %v, want the shorter document_cache_ttl", ttl)
+	}
+}
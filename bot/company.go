@@ -0,0 +1,259 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	deli "github.com/fuksman/delimobil"
+)
+
+type Company struct {
+	*deli.Company
+	Limits      map[string]float64
+	LimitAlerts map[string]LimitAlert
+	CostCenters []string
+	LastRideId  int
+
+	InvoicePresets []float64
+	KnownBalance   float64
+	Emails         []string
+	// KnownBalance is set, it may be zero
+	BalanceKnown bool
+	// The newest closing document emailed
+	LastDocumentId int
+	// Set when Delimobil rejects the saved credentials, cleared by /auth
+	NeedsReauth bool
+
+	api Delimobil
+}
+
+// ErrReauthRequired is returned for the company until an admin authenticates again.
+var ErrReauthRequired = errors.New("Делимобиль не принимает логин и пароль компании.\nАдминистратор должен заново отправить /auth login password")
+
+// NewCompany returns the company not authenticated yet, its Delimobil calls are bound to ctx.
+func (app *App) NewCompany(ctx context.Context, login, password string) (company *Company) {
+	deliCompany := app.delimobil.NewCompany(login, password)
+	return &Company{Company: deliCompany, api: app.delimobil.API(ctx, deliCompany)}
+}
+
+func (app *App) SaveCompany(ctx context.Context, company *Company) error {
+	companyLogger := app.log.WithField("companyId", company.Id)
+	companyLogger.Trace("Saving company...")
+	buf := bytes.Buffer{}
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(company); err != nil {
+		companyLogger.Warn(err)
+		return err
+	}
+
+	companyDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("companies").Doc(strconv.Itoa(company.Id))
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	if _, err := companyDocRef.Set(reqCtx, map[string]interface{}{"gob": base64.StdEncoding.EncodeToString(buf.Bytes())}); err != nil {
+		companyLogger.Warn(err)
+		return err
+	}
+
+	companyLogger.Info("Saved!")
+	return nil
+}
+
+func (app *App) LoadCompany(ctx context.Context, id int) (company *Company, err error) {
+	company, err = app.readCompany(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.AuthenticateCompany(ctx, company); err != nil {
+		return nil, err
+	}
+	return company, nil
+}
+
+// AuthenticateCompany updates the token of the company read from the storage.
+// Notifiers read the company first to authenticate only if it has something to check.
+func (app *App) AuthenticateCompany(ctx context.Context, company *Company) error {
+	companyLogger := app.log.WithField("companyId", company.Id)
+	if company.NeedsReauth {
+		companyLogger.Trace("Waiting for re-authentication")
+		return ErrReauthRequired
+	}
+
+	companyLogger.Trace("Updating token...")
+
+	if err := company.Authenticate(); err != nil {
+		companyLogger.Warn(err)
+		if isAuthFailure(err) {
+			app.RequireReauth(ctx, company)
+			return ErrReauthRequired
+		}
+		return err
+	}
+	companyLogger.Info("Token updated.")
+	return nil
+}
+
+// UpdateCompany changes the saved company in a transaction, so the fields changed meanwhile by others are kept.
+// Only the settings are changed, the company isn't authenticated.
+func (app *App) UpdateCompany(ctx context.Context, id int, update func(company *Company)) error {
+	companyLogger := app.log.WithField("companyId", id)
+	companyLogger.Trace("Updating company...")
+	companyDocRef := app.collection("companies").Doc(strconv.Itoa(id))
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	err := app.client.RunTransaction(reqCtx, func(ctx context.Context, tx *firestore.Transaction) error {
+		companyDoc, err := tx.Get(companyDocRef)
+		if err != nil {
+			return err
+		}
+		company := &Company{}
+		if err := decodeGob(companyDoc, company); err != nil {
+			return err
+		}
+		update(company)
+		encoded, err := encodeGob(company)
+		if err != nil {
+			return err
+		}
+		return tx.Set(companyDocRef, map[string]interface{}{"gob": encoded})
+	})
+	if err != nil {
+		companyLogger.Warn(err)
+		return err
+	}
+	companyLogger.Info("Updated!")
+	return nil
+}
+
+// isAuthFailure reports if Delimobil has rejected the credentials, e.g. after the password was changed.
+// Only the 401 status means it, any other error leaves the credentials as they are.
+func isAuthFailure(err error) bool {
+	status, ok := delimobilStatus(err)
+	return ok && status == http.StatusUnauthorized
+}
+
+// RequireReauth pauses working with the company and asks its admins to authenticate again.
+// It's done once, when the credentials are rejected the first time.
+func (app *App) RequireReauth(ctx context.Context, company *Company) {
+	companyLogger := app.log.WithField("companyId", company.Id)
+	companyLogger.Warn("Credentials are rejected, waiting for re-authentication")
+	company.NeedsReauth = true
+	// Of concurrent rejections only the first one notifies the admins
+	required := false
+	if err := app.UpdateCompany(ctx, company.Id, func(saved *Company) {
+		required = !saved.NeedsReauth
+		saved.NeedsReauth = true
+	}); err != nil || !required {
+		return
+	}
+
+	users, err := app.LoadCompanyUsers(ctx, company.Id)
+	if err != nil {
+		companyLogger.Warn(err)
+		return
+	}
+	name := ""
+	if company.Info.Name != "" {
+		name = " " + company.Info.Name
+	}
+	mes := "🔑 Делимобиль не принимает логин и пароль компании" + name + ".\n" +
+		"Возможно, пароль от личного кабинета изменился. Пока я не могу проверять баланс, поездки и лимиты.\n" +
+		"Чтобы продолжить, отправь команду\n/auth login password"
+	for _, user := range users {
+		if !user.Admin {
+			continue
+		}
+		if _, err := app.outbox.Send(user, mes); err != nil {
+			companyLogger.WithField("userId", user.Id).Warn(err)
+		}
+	}
+}
+
+// RestoreSettings copies the bot-side settings of the previously saved company, so re-authentication keeps them.
+func (app *App) RestoreSettings(ctx context.Context, company *Company) {
+	saved, err := app.readCompany(ctx, company.Id)
+	if err != nil {
+		return
+	}
+	company.Limits = saved.Limits
+	company.LimitAlerts = saved.LimitAlerts
+	company.CostCenters = saved.CostCenters
+	company.LastRideId = saved.LastRideId
+	company.InvoicePresets = saved.InvoicePresets
+	company.KnownBalance = saved.KnownBalance
+	company.BalanceKnown = saved.BalanceKnown
+	company.LastDocumentId = saved.LastDocumentId
+	company.Emails = saved.Emails
+}
+
+func (app *App) readCompany(ctx context.Context, id int) (company *Company, err error) {
+	companyLogger := app.log.WithField("companyId", id)
+	companyLogger.Trace("Loading company data...")
+	companyDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("companies").Doc(strconv.Itoa(id))
+
+	reqCtx, cancel := app.storageContext(ctx)
+	companyDoc, err := companyDocRef.Get(reqCtx)
+	cancel()
+	if err != nil {
+		companyLogger.Warn(err)
+		return nil, err
+	}
+
+	userGob, err := companyDoc.DataAt("gob")
+	if err != nil {
+		companyLogger.Warn(err)
+		return nil, err
+	}
+
+	by, err := base64.StdEncoding.DecodeString(userGob.(string))
+	if err != nil {
+		companyLogger.Warn(err)
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	buf.Write(by)
+	dec := gob.NewDecoder(&buf)
+	err = dec.Decode(&company)
+	if err != nil {
+		companyLogger.Warn(err)
+		return nil, err
+	}
+	company.api = app.delimobil.API(ctx, company.Company)
+
+	companyLogger.Info("Loaded!")
+	return company, nil
+}
+
+func (app *App) RemoveCompany(ctx context.Context, id int) error {
+	companyLogger := app.log.WithField("companyId", id)
+	companyLogger.Trace("Removing company data...")
+	companyDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("companies").Doc(strconv.Itoa(id))
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	if _, err := companyDocRef.Delete(reqCtx); err != nil {
+		companyLogger.Warn(err)
+		return err
+	}
+	companyLogger.Info("Deleted!")
+	return nil
+}
+
+func (app *App) Permissions(company *Company, user *User) string {
+	if user.CompanyId == company.Id && user.Admin {
+		return "admin"
+	}
+	employee, err := company.HasEmployee(user.Phone)
+	if err != nil {
+		app.log.Warn(err)
+		return ""
+	}
+	if employee {
+		return "employee"
+	}
+	return ""
+}
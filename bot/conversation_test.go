@@ -0,0 +1,243 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	deli "github.com/fuksman/delimobil"
+)
+
+const (
+	adminId    int64 = 1001
+	employeeId int64 = 1002
+)
+
+func addAcme(h *Harness) {
+	h.Delimobil.AddAccount(&FakeAccount{
+		Id:        1,
+		Login:     "acme",
+		Password:  "secret",
+		Info:      deli.Info{Name: "Acme", Balance: 1500},
+		Employees: deli.Employees{{Name: "Сотрудник", Phone: "+79990000002"}},
+	})
+}
+
+// signIn connects the company to the bot on behalf of the admin.
+func signIn(t *testing.T, h *Harness) {
+	t.Helper()
+	h.SendText(adminId, "/start")
+	h.SendContact(adminId, "+79990000001")
+	h.SendText(adminId, "/auth acme secret")
+	if texts := h.Texts(adminId); !strings.HasSuffix(texts, "Предоставлен доступ к компании Acme!\nВсё настроено, можем работать.\nДля безопасности я удалил сообщение с логином и паролем.") {
+		t.Fatalf("admin is not signed in:\n%s", texts)
+	}
+	h.Telegram.Reset()
+}
+
+func lastKeyboard(h *Harness, chatId int64) []string {
+	sent := h.Telegram.Sent(chatId)
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].Markup == nil || len(sent[i].Markup.ReplyKeyboard) == 0 {
+			continue
+		}
+		var buttons []string
+		for _, row := range sent[i].Markup.ReplyKeyboard {
+			for _, button := range row {
+				buttons = append(buttons, button.Text)
+			}
+		}
+		return buttons
+	}
+	return nil
+}
+
+func TestOnboarding(t *testing.T) {
+	h := newTestHarness(t)
+	addAcme(h)
+
+	h.SendText(adminId, "/start")
+	h.SendContact(adminId, "+79990000001")
+	h.SendText(adminId, "Я администратор")
+	h.SendText(adminId, "/auth acme secret")
+	want := strings.Join([]string{
+		"Для работы мне нужен твой телефонный номер.",
+		"Сохранил, но не могу найти ни одну подходящую компанию.\nАдминистратор должен подключить компанию к боту или добавить тебя в список сотрудников.",
+		"Отправь команду\n`/auth login password`\n где `login` и `password` — реквизиты доступа к личному кабинету Делимобиль",
+		"Предоставлен доступ к компании Acme!\nВсё настроено, можем работать.\nДля безопасности я удалил сообщение с логином и паролем.",
+	}, "\n")
+	if texts := h.Texts(adminId); texts != want {
+		t.Errorf("admin conversation:\n%s\nwant:\n%s", texts, want)
+	}
+	if keyboard := strings.Join(lastKeyboard(h, adminId), ", "); !strings.Contains(keyboard, "Новый счёт") {
+		t.Errorf("admin keyboard: %s", keyboard)
+	}
+
+	h.SendText(employeeId, "/start")
+	h.SendContact(employeeId, "+79990000002")
+	h.SendText(employeeId, "Баланс")
+	want = strings.Join([]string{
+		"Для работы мне нужен твой телефонный номер.",
+		"👋 Привет!\nТеперь у тебя есть доступ к компании Acme",
+		"Acme\nТекущий баланс: 1500.00 ₽",
+	}, "\n")
+	if texts := h.Texts(employeeId); texts != want {
+		t.Errorf("employee conversation:\n%s\nwant:\n%s", texts, want)
+	}
+	if keyboard := strings.Join(lastKeyboard(h, employeeId), ", "); !strings.Contains(keyboard, "Запросить пополнение") || strings.Contains(keyboard, "Новый счёт") {
+		t.Errorf("employee keyboard: %s", keyboard)
+	}
+}
+
+func TestInvoiceWithCustomAmount(t *testing.T) {
+	h := newTestHarness(t)
+	addAcme(h)
+	signIn(t, h)
+
+	h.SendText(adminId, "Новый счёт")
+	if err := h.Press(adminId, "Другая сумма"); err != nil {
+		t.Fatal(err)
+	}
+	h.SendText(adminId, "двенадцать тысяч")
+	h.SendText(adminId, "12 345,50")
+	if err := h.Press(adminId, "Создать"); err != nil {
+		t.Fatal(err)
+	}
+	// The confirmation can't create the invoice twice
+	if err := h.Press(adminId, "Создать"); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"Какой нужен счёт?",
+		"На какую сумму нужен счёт?\nОт 1000 до 1000000 ₽",
+		"не могу разобрать сумму, пришли только число, например 15000\nПопробуй ещё раз или нажми «Отмена»",
+		"Создать счёт на 12 345,50 ₽?",
+		"[invoice-1.pdf]",
+		"Этот счёт уже создан или отменён",
+	}, "\n")
+	if texts := h.Texts(adminId); texts != want {
+		t.Errorf("conversation:\n%s\nwant:\n%s", texts, want)
+	}
+}
+
+func TestInvoiceCancelled(t *testing.T) {
+	h := newTestHarness(t)
+	addAcme(h)
+	signIn(t, h)
+
+	h.SendText(adminId, "Новый счёт")
+	if err := h.Press(adminId, "Другая сумма"); err != nil {
+		t.Fatal(err)
+	}
+	h.SendText(adminId, "5000")
+	if err := h.Press(adminId, "Отмена"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Press(adminId, "Создать"); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"Какой нужен счёт?",
+		"На какую сумму нужен счёт?\nОт 1000 до 1000000 ₽",
+		"Создать счёт на 5 000 ₽?",
+		"Отменил",
+		"Этот счёт уже создан или отменён",
+	}, "\n")
+	if texts := h.Texts(adminId); texts != want {
+		t.Errorf("conversation:\n%s\nwant:\n%s", texts, want)
+	}
+}
+
+func TestInvoiceConfirmedTwiceAtOnce(t *testing.T) {
+	h := newTestHarness(t)
+	addAcme(h)
+	signIn(t, h)
+
+	h.SendText(adminId, "Новый счёт")
+	if err := h.Press(adminId, "Другая сумма"); err != nil {
+		t.Fatal(err)
+	}
+	h.SendText(adminId, "5000")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.Press(adminId, "Создать"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var invoices, refused int
+	for _, sent := range h.Telegram.Sent(adminId) {
+		switch {
+		case sent.Document != "":
+			invoices++
+		case sent.Text == "Этот счёт уже создан или отменён":
+			refused++
+		}
+	}
+	if invoices != 1 || refused != 1 {
+		t.Errorf("%d invoices are sent and %d presses are refused, want one of each:\n%s", invoices, refused, h.Texts(adminId))
+	}
+}
+
+// ageDialog moves the last reply of the dialog in the chat back in time.
+func ageDialog(t *testing.T, h *Harness, chatId int64, age time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	state, err := h.App.LoadDialogState(ctx, chatId)
+	if err != nil || state == nil {
+		t.Fatalf("no dialog in chat %d: %v", chatId, err)
+	}
+	state.UpdatedAt = time.Now().Add(-age)
+	if err := h.App.SaveDialogState(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialogTimeout(t *testing.T) {
+	h := newTestHarness(t)
+	addAcme(h)
+	signIn(t, h)
+
+	h.SendText(adminId, "Новый счёт")
+	if err := h.Press(adminId, "Другая сумма"); err != nil {
+		t.Fatal(err)
+	}
+	ageDialog(t, h, adminId, defaultDialogTimeout-time.Minute)
+	h.SendText(adminId, "12000")
+	ageDialog(t, h, adminId, defaultDialogTimeout+time.Minute)
+	if err := h.Press(adminId, "Создать"); err != nil {
+		t.Fatal(err)
+	}
+
+	h.SendText(adminId, "Новый счёт")
+	if err := h.Press(adminId, "Другая сумма"); err != nil {
+		t.Fatal(err)
+	}
+	ageDialog(t, h, adminId, defaultDialogTimeout+time.Minute)
+	h.SendText(adminId, "12000")
+	// The expired dialog is forgotten, so the amount isn't expected anymore
+	h.SendText(adminId, "12000")
+	want := strings.Join([]string{
+		"Какой нужен счёт?",
+		"На какую сумму нужен счёт?\nОт 1000 до 1000000 ₽",
+		"Создать счёт на 12 000 ₽?",
+		"Этот счёт уже создан или отменён",
+		"Какой нужен счёт?",
+		"На какую сумму нужен счёт?\nОт 1000 до 1000000 ₽",
+		"⌛️ Слишком долго ждал ответа, начни сначала",
+	}, "\n")
+	if texts := h.Texts(adminId); texts != want {
+		t.Errorf("conversation:\n%s\nwant:\n%s", texts, want)
+	}
+	if state, err := h.App.LoadDialogState(context.Background(), adminId); err != nil || state != nil {
+		t.Errorf("expired dialog is left: %+v, %v", state, err)
+	}
+}
@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"errors"
+
+	deli "github.com/fuksman/delimobil"
+)
+
+// Delimobil is the part of Delimobil b2b API the bot works with.
+// The methods fill the data of the company they are bound to.
+type Delimobil interface {
+	Authenticate() error
+	SetInfo() error
+	SetRides(count, page int) error
+	SetEmployees() error
+	HasEmployee(phone string) (bool, error)
+	CreateInvoice(amount float64) (*deli.File, error)
+	LastFileByType(fileType string) (*deli.File, error)
+	SetFiles() error
+	FileById(id int) (*deli.File, error)
+}
+
+// DelimobilClient creates company data and binds API to it, so the bot may work with a fake Delimobil.
+type DelimobilClient interface {
+	// NewCompany returns the data of the company not authenticated yet
+	NewCompany(login, password string) *deli.Company
+	// API returns operations filling the data in
+	API(data *deli.Company) Delimobil
+}
+
+// RealDelimobil works with Delimobil itself.
+type RealDelimobil struct{}
+
+func (RealDelimobil) NewCompany(login, password string) *deli.Company {
+	return deli.NewCompany(login, password)
+}
+
+func (RealDelimobil) API(data *deli.Company) Delimobil {
+	return data
+}
+
+// DelimobilStatusError is the failure of the call Delimobil has answered with the HTTP status.
+type DelimobilStatusError struct {
+	Code int
+	Err  error
+}
+
+func (e *DelimobilStatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DelimobilStatusError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DelimobilStatusError) StatusCode() int {
+	return e.Code
+}
+
+// delimobilStatus returns the HTTP status of the failed call, if the error of the client reports it.
+func delimobilStatus(err error) (int, bool) {
+	var coder interface{ StatusCode() int }
+	if errors.As(err, &coder) {
+		return coder.StatusCode(), true
+	}
+	return 0, false
+}
+
+// Delimobil API calls of the company go through its API, which observes them for metrics.
+
+func (company *Company) Authenticate() (err error) {
+	return company.api.Authenticate()
+}
+
+func (company *Company) SetInfo() (err error) {
+	return company.api.SetInfo()
+}
+
+func (company *Company) SetRides(count, page int) (err error) {
+	return company.api.SetRides(count, page)
+}
+
+func (company *Company) SetEmployees() (err error) {
+	return company.api.SetEmployees()
+}
+
+func (company *Company) HasEmployee(phone string) (exist bool, err error) {
+	return company.api.HasEmployee(phone)
+}
+
+func (company *Company) CreateInvoice(amount float64) (invoice *deli.File, err error) {
+	return company.api.CreateInvoice(amount)
+}
+
+func (company *Company) LastFileByType(fileType string) (file *deli.File, err error) {
+	return company.api.LastFileByType(fileType)
+}
+
+func (company *Company) SetFiles() (err error) {
+	return company.api.SetFiles()
+}
+
+func (company *Company) FileById(id int) (file *deli.File, err error) {
+	return company.api.FileById(id)
+}
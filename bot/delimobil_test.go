@@ -0,0 +1,29 @@
+package bot
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestIsAuthFailure(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&DelimobilStatusError{Code: http.StatusUnauthorized, Err: errors.New("unauthorized")}, true},
+		{errFakeUnauthorized, true},
+		// The numbers in the message are not the status
+		{errors.New("ride 401 not found"), false},
+		{errors.New("не могу выставить счёт на 401 ₽: unauthorized amount"), false},
+		{&DelimobilStatusError{Code: http.StatusNotFound, Err: errors.New("company 401 not found")}, false},
+		{&DelimobilStatusError{Code: http.StatusInternalServerError, Err: errors.New("unexpected status 500")}, false},
+		{io.ErrUnexpectedEOF, false},
+		{ErrDelimobilUnavailable, false},
+	} {
+		if got := isAuthFailure(test.err); got != test.want {
+			t.Errorf("isAuthFailure(%q) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+const defaultDialogTimeout = 10 * time.Minute
+
+// StepFunc handles the reply to the question of the dialog step.
+// It should move the dialog on with state.Next or finish it with state.Finish.
+type StepFunc func(tlg tele.Context, state *DialogState) error
+
+// Dialog is a multi-step conversation declared by handlers.
+// Middleware is applied to every step, so steps can rely on the same context as ordinary handlers.
+type Dialog struct {
+	Name       string
+	Timeout    time.Duration
+	Middleware []tele.MiddlewareFunc
+	Steps      map[string]StepFunc
+}
+
+// DialogState is the current step of the dialog in the chat, persisted between updates.
+type DialogState struct {
+	ChatId    int64
+	Dialog    string
+	Step      string
+	Data      map[string]string
+	UpdatedAt time.Time
+}
+
+func (app *App) RegisterDialog(dialog *Dialog) {
+	if dialog.Timeout == 0 {
+		dialog.Timeout = defaultDialogTimeout
+	}
+	app.dialogs[dialog.Name] = dialog
+}
+
+// StartDialog replaces any dialog of the chat with the new one waiting for the reply to the step.
+func (app *App) StartDialog(tlg tele.Context, dialog, step string) (*DialogState, error) {
+	ctx := UpdateContext(tlg)
+	state := &DialogState{ChatId: tlg.Chat().ID, Dialog: dialog, Data: make(map[string]string)}
+	return state, app.NextStep(ctx, state, step)
+}
+
+func (app *App) NextStep(ctx context.Context, state *DialogState, step string) error {
+	state.Step = step
+	state.UpdatedAt = time.Now()
+	return app.SaveDialogState(ctx, state)
+}
+
+func (app *App) FinishDialog(ctx context.Context, state *DialogState) error {
+	return app.RemoveDialogState(ctx, state.ChatId)
+}
+
+func (state *DialogState) Is(dialog, step string) bool {
+	return state != nil && state.Dialog == dialog && state.Step == step
+}
+
+func (state *DialogState) Float(key string) float64 {
+	val, _ := strconv.ParseFloat(state.Data[key], 64)
+	return val
+}
+
+func (state *DialogState) SetFloat(key string, val float64) {
+	state.Data[key] = strconv.FormatFloat(val, 'f', -1, 64)
+}
+
+func (app *App) DialogExpired(state *DialogState) bool {
+	dialog, ok := app.dialogs[state.Dialog]
+	return !ok || time.Since(state.UpdatedAt) > dialog.Timeout
+}
+
+// ActiveDialogState returns the not expired dialog state of the chat or nil.
+func (app *App) ActiveDialogState(ctx context.Context, chatId int64) *DialogState {
+	state, err := app.LoadDialogState(ctx, chatId)
+	if err != nil || state == nil {
+		return nil
+	}
+	if app.DialogExpired(state) {
+		app.FinishDialog(ctx, state)
+		return nil
+	}
+	return state
+}
+
+// HandleDialogReply passes the text to the step the chat is waiting for, text without a dialog is ignored.
+func (app *App) HandleDialogReply(tlg tele.Context) error {
+	ctx := UpdateContext(tlg)
+	state, err := app.LoadDialogState(ctx, tlg.Chat().ID)
+	if err != nil || state == nil {
+		return nil
+	}
+	if app.DialogExpired(state) {
+		app.FinishDialog(ctx, state)
+		return tlg.Send("⌛️ Слишком долго ждал ответа, начни сначала")
+	}
+
+	step, ok := app.dialogs[state.Dialog].Steps[state.Step]
+	if !ok {
+		return tlg.Send("Ответь с помощью кнопок или нажми «Отмена»", app.cancelMenu)
+	}
+	handler := func(tlg tele.Context) error {
+		return step(tlg, state)
+	}
+	middleware := app.dialogs[state.Dialog].Middleware
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler(tlg)
+}
+
+// CancelDialog is the escape from any dialog.
+func (app *App) CancelDialog(tlg tele.Context) error {
+	ctx := UpdateContext(tlg)
+	if err := app.RemoveDialogState(ctx, tlg.Chat().ID); err != nil {
+		return tlg.Send(err.Error())
+	}
+	menu := app.startMenu
+	if user, err := app.LoadUser(ctx, tlg.Sender().ID); err == nil {
+		menu = app.unAuthMenu
+		if company, err := app.LoadCompany(ctx, user.CompanyId); err == nil {
+			switch app.Permissions(company, user) {
+			case "admin":
+				menu = app.adminMenu
+			case "employee":
+				menu = app.emplMenu
+			}
+		}
+	}
+	return tlg.Send("Отменил", menu)
+}
+
+func (app *App) SaveDialogState(ctx context.Context, state *DialogState) error {
+	chatLogger := app.log.WithField("chatId", state.ChatId)
+	chatLogger.Trace("Saving dialog state...")
+	buf := bytes.Buffer{}
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(state); err != nil {
+		chatLogger.Warn(err)
+		return err
+	}
+
+	stateDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("dialogs").Doc(strconv.FormatInt(state.ChatId, 10))
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	if _, err := stateDocRef.Set(reqCtx, map[string]interface{}{"gob": base64.StdEncoding.EncodeToString(buf.Bytes())}); err != nil {
+		chatLogger.Warn(err)
+		return err
+	}
+
+	chatLogger.Info("Saved!")
+	return nil
+}
+
+// LoadDialogState returns nil state without error if the chat has no dialog.
+func (app *App) LoadDialogState(ctx context.Context, chatId int64) (state *DialogState, err error) {
+	chatLogger := app.log.WithField("chatId", chatId)
+	chatLogger.Trace("Loading dialog state...")
+	stateDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("dialogs").Doc(strconv.FormatInt(chatId, 10))
+
+	reqCtx, cancel := app.storageContext(ctx)
+	stateDoc, err := stateDocRef.Get(reqCtx)
+	cancel()
+	if err != nil {
+		if stateDoc != nil && !stateDoc.Exists() {
+			return nil, nil
+		}
+		chatLogger.Warn(err)
+		return nil, err
+	}
+
+	stateGob, err := stateDoc.DataAt("gob")
+	if err != nil {
+		chatLogger.Warn(err)
+		return nil, err
+	}
+
+	by, err := base64.StdEncoding.DecodeString(stateGob.(string))
+	if err != nil {
+		chatLogger.Warn(err)
+		return nil, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(by)).Decode(&state); err != nil {
+		chatLogger.Warn(err)
+		return nil, err
+	}
+
+	chatLogger.Info("Loaded!")
+	return state, nil
+}
+
+func (app *App) RemoveDialogState(ctx context.Context, chatId int64) error {
+	chatLogger := app.log.WithField("chatId", chatId)
+	chatLogger.Trace("Removing dialog state...")
+	stateDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("dialogs").Doc(strconv.FormatInt(chatId, 10))
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	if _, err := stateDocRef.Delete(reqCtx); err != nil {
+		chatLogger.Warn(err)
+		return err
+	}
+	chatLogger.Info("Deleted!")
+	return nil
+}
+
+// ConsumeDialogState finishes the not expired dialog of the chat if it waits at the step and returns its state.
+// The state is read and removed in a single transaction, so of concurrent calls only one gets it, others get nil.
+func (app *App) ConsumeDialogState(ctx context.Context, chatId int64, dialog, step string) (state *DialogState, err error) {
+	chatLogger := app.log.WithField("chatId", chatId)
+	chatLogger.Trace("Consuming dialog state...")
+	stateDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("dialogs").Doc(strconv.FormatInt(chatId, 10))
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	err = app.client.RunTransaction(reqCtx, func(ctx context.Context, tx *firestore.Transaction) error {
+		state = nil
+		stateDoc, err := tx.Get(stateDocRef)
+		if err != nil {
+			if stateDoc != nil && !stateDoc.Exists() {
+				return nil
+			}
+			return err
+		}
+		saved := &DialogState{}
+		if err := decodeGob(stateDoc, saved); err != nil {
+			return err
+		}
+		if !saved.Is(dialog, step) || app.DialogExpired(saved) {
+			return nil
+		}
+		state = saved
+		return tx.Delete(stateDocRef)
+	})
+	if err != nil {
+		chatLogger.Warn(err)
+		return nil, err
+	}
+	chatLogger.Info("Consumed!")
+	return state, nil
+}
@@ -0,0 +1,435 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	deli "github.com/fuksman/delimobil"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+// EmailLogEntry is a record of the email delivery attempt.
+type EmailLogEntry struct {
+	Id        string
+	CompanyId int
+	To        []string
+	Subject   string
+	Files     []string
+	SentAt    time.Time
+	Error     string
+}
+
+// Attachment is a file already read into memory, so it can be sent to several recipients.
+type Attachment struct {
+	FileName string
+	MIME     string
+	Data     []byte
+}
+
+const (
+	maxEmails   = 5
+	smtpTimeout = 30 * time.Second
+)
+
+var emailRx = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+func ReadAttachment(file *deli.File) (*Attachment, error) {
+	data, err := io.ReadAll(file.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &Attachment{FileName: file.FileName, MIME: file.MIME, Data: data}, nil
+}
+
+func (attachment *Attachment) File() *deli.File {
+	return &deli.File{Data: bytes.NewReader(attachment.Data), FileName: attachment.FileName, MIME: attachment.MIME}
+}
+
+func (app *App) SetEmails(ctx context.Context, company *Company, list string) error {
+	var emails []string
+	for _, email := range strings.Split(list, ",") {
+		email = strings.TrimSpace(email)
+		if email == "" {
+			continue
+		}
+		if !emailRx.MatchString(email) {
+			return errors.New("неверный адрес: " + email)
+		}
+		emails = append(emails, email)
+	}
+	if len(emails) > maxEmails {
+		return errors.New("можно указать не больше " + strconv.Itoa(maxEmails) + " адресов")
+	}
+	if err := app.UpdateCompany(ctx, company.Id, func(saved *Company) {
+		saved.Emails = emails
+	}); err != nil {
+		return err
+	}
+	company.Emails = emails
+	return nil
+}
+
+func buildEmail(from string, to []string, subject, body string, attachments []*Attachment) ([]byte, error) {
+	buf := bytes.Buffer{}
+	w := multipart.NewWriter(&buf)
+	header := "From: " + from + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=" + w.Boundary() + "\r\n\r\n"
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBase64(part, []byte(body)); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range attachments {
+		contentType := attachment.MIME
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(part, attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return append([]byte(header), buf.Bytes()...), nil
+}
+
+// writeBase64 writes data in lines of 76 characters as required by RFC 2045.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err := io.WriteString(w, encoded+"\r\n")
+	return err
+}
+
+// SendEmail sends the message through the configured SMTP server.
+// Without username no authentication is used, which is enough for a local SMTP stand-in.
+// The whole conversation with the server is limited by smtpTimeout and the deadline of ctx.
+func (app *App) SendEmail(ctx context.Context, to []string, subject, body string, attachments []*Attachment) error {
+	config := app.config.SMTP
+	if config == nil {
+		return errors.New("отправка почты не настроена")
+	}
+	msg, err := buildEmail(config.From, to, subject, body, attachments)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// Cancellation of ctx interrupts the conversation as well
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: config.Host}); err != nil {
+			return err
+		}
+	}
+	if config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(config.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// EmailDocuments sends the documents to the company emails, if any, and writes the delivery log.
+// The error of sending is returned, so the caller may try again later.
+func (app *App) EmailDocuments(ctx context.Context, company *Company, subject string, attachments []*Attachment) error {
+	if app.config.SMTP == nil || len(company.Emails) == 0 || len(attachments) == 0 {
+		return nil
+	}
+	companyLogger := app.log.WithField("companyId", company.Id)
+	companyLogger.Trace("Emailing documents...")
+
+	entry := &EmailLogEntry{
+		Id:        strconv.Itoa(company.Id) + "-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		CompanyId: company.Id,
+		To:        company.Emails,
+		Subject:   subject,
+		SentAt:    time.Now(),
+	}
+	for _, attachment := range attachments {
+		entry.Files = append(entry.Files, attachment.FileName)
+	}
+	body := "Документы Делимобиль для компании " + company.Info.Name + " во вложении.\r\n"
+	err := app.SendEmail(ctx, company.Emails, subject, body, attachments)
+	if err != nil {
+		companyLogger.Warn(err)
+		entry.Error = err.Error()
+	} else {
+		companyLogger.Info("Emailed documents.")
+	}
+	app.SaveEmailLogEntry(ctx, entry)
+	return err
+}
+
+// EmailNewClosingDocuments emails closing documents appeared in Delimobil since the last check to the companies having emails.
+func (app *App) EmailNewClosingDocuments(ctx context.Context, b Sender) {
+	if app.config.SMTP == nil {
+		return
+	}
+	app.log.Trace("Emailing new closing documents...")
+	reqCtx, cancel := app.storageContext(ctx)
+	companyDocRefs, err := app.collection("companies").DocumentRefs(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		app.log.Warn(err)
+		return
+	}
+
+	for _, companyDocRef := range companyDocRefs {
+		companyId, err := strconv.Atoi(companyDocRef.ID)
+		if err != nil {
+			app.log.Warn(err)
+			continue
+		}
+
+		companyLogger := app.log.WithField("companyId", companyId)
+		company, err := app.readCompany(ctx, companyId)
+		if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		if len(company.Emails) == 0 {
+			continue
+		}
+		if err := app.AuthenticateCompany(ctx, company); errors.Is(err, ErrReauthRequired) {
+			continue
+		} else if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		if err := company.SetFiles(); err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+
+		lastDocumentId := company.LastDocumentId
+		var fresh []deli.FileInfo
+		for _, file := range company.Files {
+			if !isClosingDocument(file.Type) {
+				continue
+			}
+			if file.Id > lastDocumentId {
+				lastDocumentId = file.Id
+			}
+			if file.Id > company.LastDocumentId {
+				fresh = append(fresh, file)
+			}
+		}
+		if lastDocumentId == company.LastDocumentId {
+			continue
+		}
+		// The first check only remembers where we are, not to email the whole archive.
+		if company.LastDocumentId != 0 {
+			var attachments []*Attachment
+			for _, file := range fresh {
+				data, err := company.FileById(file.Id)
+				if err == nil {
+					var attachment *Attachment
+					if attachment, err = ReadAttachment(data); err == nil {
+						attachments = append(attachments, attachment)
+					}
+				}
+				if err != nil {
+					companyLogger.Warn(err)
+					break
+				}
+			}
+			// Failed documents are tried again on the next check
+			if len(attachments) != len(fresh) {
+				continue
+			}
+			// Unsent documents are tried again on the next check as well
+			if err := app.EmailDocuments(ctx, company, "Закрывающие документы Делимобиль", attachments); err != nil {
+				continue
+			}
+		}
+		app.UpdateCompany(ctx, company.Id, func(saved *Company) {
+			saved.LastDocumentId = lastDocumentId
+		})
+	}
+	app.log.Trace("Emailed new closing documents")
+}
+
+func isClosingDocument(fileType string) bool {
+	for _, closingType := range closingDocumentTypes {
+		if fileType == closingType {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) SaveEmailLogEntry(ctx context.Context, entry *EmailLogEntry) error {
+	entryLogger := app.log.WithField("companyId", entry.CompanyId)
+	if err := app.saveGob(ctx, app.collection("emailLog").Doc(entry.Id), entry, map[string]interface{}{"companyId": entry.CompanyId}); err != nil {
+		entryLogger.Warn(err)
+		return err
+	}
+	return nil
+}
+
+// LoadEmailLog returns delivery log of the company, the newest first.
+func (app *App) LoadEmailLog(ctx context.Context, companyId int) (entries []*EmailLogEntry, err error) {
+	companyLogger := app.log.WithField("companyId", companyId)
+	reqCtx, cancel := app.storageContext(ctx)
+	entryDocs, err := app.collection("emailLog").Where("companyId", "==", companyId).Documents(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		companyLogger.Warn(err)
+		return nil, err
+	}
+	for _, entryDoc := range entryDocs {
+		entry := &EmailLogEntry{}
+		if err := decodeGob(entryDoc, entry); err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].SentAt.After(entries[j].SentAt)
+	})
+	return entries, nil
+}
+
+func (app *App) Emails(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	if app.config.SMTP == nil {
+		return (*tlg).Send("Отправка почты не настроена в конфигурации бота", menu)
+	}
+	if list := (*tlg).Data(); list != "" {
+		if list == "-" {
+			list = ""
+		}
+		if err := app.SetEmails(ctx, company, list); err != nil {
+			return (*tlg).Send(err.Error(), menu)
+		}
+	}
+	if len(company.Emails) == 0 {
+		return (*tlg).Send("Новые счета и закрывающие документы не отправляются на почту.\nДобавить адреса: /emails buh@example.com, boss@example.com", menu)
+	}
+	return (*tlg).Send("Новые счета и закрывающие документы отправляются на:\n"+strings.Join(company.Emails, "\n")+
+		"\n\nИзменить: /emails адрес 1, адрес 2\nОтключить: /emails -\nЖурнал отправки: /emaillog", menu)
+}
+
+func (app *App) EmailLog(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	entries, err := app.LoadEmailLog(ctx, company.Id)
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if len(entries) == 0 {
+		return (*tlg).Send("Писем ещё не отправлялось", menu)
+	}
+	if len(entries) > 10 {
+		entries = entries[:10]
+	}
+	mes := "Последние письма:\n"
+	for _, entry := range entries {
+		status := "✅"
+		if entry.Error != "" {
+			status = "❌ " + entry.Error
+		}
+		mes += "\n" + entry.SentAt.Format("02.01.2006 15:04") + " " + entry.Subject + "\n" +
+			strings.Join(entry.To, ", ") + "\n" + status + "\n"
+	}
+	return (*tlg).Send(mes, menu)
+}
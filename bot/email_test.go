@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is the SMTP server accepting or rejecting every message, the accepted ones are recorded.
+type fakeSMTP struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	reject   bool
+	silent   bool
+	messages []string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &fakeSMTP{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *fakeSMTP) Config() *SMTPConfig {
+	addr := server.listener.Addr().(*net.TCPAddr)
+	return &SMTPConfig{Host: addr.IP.String(), Port: addr.Port, From: "bot@example.com"}
+}
+
+func (server *fakeSMTP) Set(reject, silent bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.reject = reject
+	server.silent = silent
+}
+
+func (server *fakeSMTP) Messages() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return append([]string(nil), server.messages...)
+}
+
+func (server *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	server.mu.Lock()
+	reject, silent := server.reject, server.silent
+	server.mu.Unlock()
+	if silent {
+		// Hangs up only when the client gives up
+		conn.Read(make([]byte, 1))
+		return
+	}
+
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake SMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); {
+		case command == "EHLO" || command == "HELO":
+			text.PrintfLine("250 fake")
+		case command == "MAIL" && reject:
+			text.PrintfLine("554 rejected")
+		case command == "MAIL" || command == "RCPT" || command == "RSET" || command == "NOOP":
+			text.PrintfLine("250 OK")
+		case command == "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.messages = append(server.messages, string(data))
+			server.mu.Unlock()
+			text.PrintfLine("250 OK")
+		case command == "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	server := newFakeSMTP(t)
+	app := newOfflineApp(t, newFakeAcme())
+	app.config.SMTP = server.Config()
+
+	attachment := &Attachment{FileName: "invoice-1.pdf", MIME: "application/pdf", Data: []byte("%PDF")}
+	if err := app.SendEmail(context.Background(), []string{"buh@example.com"}, "Счёт", "Во вложении", []*Attachment{attachment}); err != nil {
+		t.Fatal(err)
+	}
+	messages := server.Messages()
+	if len(messages) != 1 || !strings.Contains(messages[0], "To: buh@example.com") || !strings.Contains(messages[0], `filename=invoice-1.pdf`) {
+		t.Errorf("messages = %q", messages)
+	}
+
+	server.Set(true, false)
+	if err := app.SendEmail(context.Background(), []string{"buh@example.com"}, "Счёт", "Во вложении", nil); err == nil {
+		t.Error("rejected message is sent without error")
+	}
+}
+
+func TestSendEmailDeadline(t *testing.T) {
+	server := newFakeSMTP(t)
+	server.Set(false, true)
+	app := newOfflineApp(t, newFakeAcme())
+	app.config.SMTP = server.Config()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := app.SendEmail(ctx, []string{"buh@example.com"}, "Счёт", "Во вложении", nil); err == nil {
+		t.Error("silent server has got the message")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("silent server has held the bot for %v", elapsed)
+	}
+}
+
+func TestClosingDocumentsAreEmailedOnceSent(t *testing.T) {
+	server := newFakeSMTP(t)
+	h := newTestHarness(t)
+	h.App.config.SMTP = server.Config()
+	addAcme(h)
+	signIn(t, h)
+	h.SendText(adminId, "/emails buh@example.com")
+	ctx := context.Background()
+
+	h.Delimobil.AddFile(1, "upd", "upd-1.pdf", time.Now(), []byte("%PDF"))
+	h.App.EmailNewClosingDocuments(ctx, h.App.outbox)
+	if messages := server.Messages(); len(messages) != 0 {
+		t.Fatalf("the archive is emailed on the first check: %q", messages)
+	}
+
+	lastId := h.Delimobil.AddFile(1, "upd", "upd-2.pdf", time.Now(), []byte("%PDF"))
+	server.Set(true, false)
+	h.App.EmailNewClosingDocuments(ctx, h.App.outbox)
+	company, err := h.App.readCompany(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if company.LastDocumentId == lastId {
+		t.Error("the document not emailed is remembered as sent")
+	}
+
+	server.Set(false, false)
+	h.App.EmailNewClosingDocuments(ctx, h.App.outbox)
+	if company, err = h.App.readCompany(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if company.LastDocumentId != lastId {
+		t.Errorf("last document id = %d, want %d", company.LastDocumentId, lastId)
+	}
+	if messages := server.Messages(); len(messages) != 1 || !strings.Contains(messages[0], "upd-2.pdf") {
+		t.Errorf("messages = %q", messages)
+	}
+
+	entries, err := h.App.LoadEmailLog(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Error != "" || entries[1].Error == "" {
+		t.Errorf("email log = %+v, want the failure and then the success", entries)
+	}
+}
@@ -0,0 +1,290 @@
+package bot
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	deli "github.com/fuksman/delimobil"
+)
+
+// FakeDelimobil is an in-memory Delimobil to run the bot offline, e.g. in end-to-end tests.
+// Accounts are scripted with its methods, which are safe to call while the bot is running.
+type FakeDelimobil struct {
+	mu         sync.Mutex
+	accounts   map[int]*FakeAccount
+	lastFileId int
+}
+
+// FakeAccount is the company account in the fake Delimobil.
+type FakeAccount struct {
+	Id        int
+	Login     string
+	Password  string
+	Info      deli.Info
+	Rides     deli.Rides
+	Employees deli.Employees
+	Files     []*FakeFile
+	// Err is returned by every call while set to script Delimobil failures
+	Err error
+	// Set when the password is changed, so the bot can't authenticate until it logs in again
+	revoked bool
+}
+
+type FakeFile struct {
+	deli.FileInfo
+	MIME string
+	Data []byte
+}
+
+// ErrDelimobilUnauthorized is the message of the fake Delimobil rejecting the login or password with 401 status.
+var ErrDelimobilUnauthorized = errors.New("Делимобиль не принимает логин и пароль")
+
+var errFakeUnauthorized = &DelimobilStatusError{Code: http.StatusUnauthorized, Err: ErrDelimobilUnauthorized}
+
+func NewFakeDelimobil() *FakeDelimobil {
+	return &FakeDelimobil{accounts: make(map[int]*FakeAccount)}
+}
+
+func (fake *FakeDelimobil) AddAccount(account *FakeAccount) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.accounts[account.Id] = account
+}
+
+func (fake *FakeDelimobil) SetBalance(id int, balance float64) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if account, ok := fake.accounts[id]; ok {
+		account.Info.Balance = balance
+	}
+}
+
+// SetPassword changes the password like in the web account, revoking the access of the bot.
+func (fake *FakeDelimobil) SetPassword(id int, password string) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if account, ok := fake.accounts[id]; ok {
+		account.Password = password
+		account.revoked = true
+	}
+}
+
+func (fake *FakeDelimobil) SetError(id int, err error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if account, ok := fake.accounts[id]; ok {
+		account.Err = err
+	}
+}
+
+// AddRide adds the ride as the newest one.
+func (fake *FakeDelimobil) AddRide(id int, ride deli.Ride) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if account, ok := fake.accounts[id]; ok {
+		account.Rides = append(deli.Rides{ride}, account.Rides...)
+	}
+}
+
+func (fake *FakeDelimobil) AddEmployee(id int, employee deli.Employee) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if account, ok := fake.accounts[id]; ok {
+		account.Employees = append(account.Employees, employee)
+	}
+}
+
+// AddFile adds the document to the account and returns its id.
+func (fake *FakeDelimobil) AddFile(id int, fileType, fileName string, date time.Time, data []byte) int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return fake.addFile(id, fileType, fileName, date, data)
+}
+
+func (fake *FakeDelimobil) addFile(id int, fileType, fileName string, date time.Time, data []byte) int {
+	account, ok := fake.accounts[id]
+	if !ok {
+		return 0
+	}
+	fake.lastFileId++
+	account.Files = append(account.Files, &FakeFile{
+		FileInfo: deli.FileInfo{Id: fake.lastFileId, Type: fileType, Date: date, FileName: fileName},
+		MIME:     "application/pdf",
+		Data:     data,
+	})
+	return fake.lastFileId
+}
+
+// NewCompany returns the data with id of the account if the credentials are right, otherwise authentication fails.
+func (fake *FakeDelimobil) NewCompany(login, password string) *deli.Company {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, account := range fake.accounts {
+		if account.Login == login && account.Password == password {
+			account.revoked = false
+			return &deli.Company{Id: account.Id}
+		}
+	}
+	return &deli.Company{}
+}
+
+func (fake *FakeDelimobil) API(data *deli.Company) Delimobil {
+	return &fakeAPI{fake: fake, data: data}
+}
+
+type fakeAPI struct {
+	fake *FakeDelimobil
+	data *deli.Company
+}
+
+// account locks the fake until the returned unlock is called.
+func (api *fakeAPI) account() (account *FakeAccount, unlock func(), err error) {
+	api.fake.mu.Lock()
+	account, ok := api.fake.accounts[api.data.Id]
+	if !ok {
+		api.fake.mu.Unlock()
+		return nil, nil, errFakeUnauthorized
+	}
+	if account.Err != nil {
+		api.fake.mu.Unlock()
+		return nil, nil, account.Err
+	}
+	return account, api.fake.mu.Unlock, nil
+}
+
+func (api *fakeAPI) Authenticate() error {
+	account, unlock, err := api.account()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if account.revoked {
+		return errFakeUnauthorized
+	}
+	return nil
+}
+
+func (api *fakeAPI) SetInfo() error {
+	account, unlock, err := api.account()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	api.data.Info = account.Info
+	return nil
+}
+
+func (api *fakeAPI) SetRides(count, page int) error {
+	account, unlock, err := api.account()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	from := (page - 1) * count
+	if from < 0 || from > len(account.Rides) {
+		from = len(account.Rides)
+	}
+	to := from + count
+	if to > len(account.Rides) {
+		to = len(account.Rides)
+	}
+	api.data.Rides = append(deli.Rides{}, account.Rides[from:to]...)
+	return nil
+}
+
+func (api *fakeAPI) SetEmployees() error {
+	account, unlock, err := api.account()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	api.data.Employees = append(deli.Employees{}, account.Employees...)
+	return nil
+}
+
+func (api *fakeAPI) HasEmployee(phone string) (bool, error) {
+	account, unlock, err := api.account()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	for _, employee := range account.Employees {
+		if NormalizePhone(employee.Phone) == NormalizePhone(phone) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (api *fakeAPI) CreateInvoice(amount float64) (*deli.File, error) {
+	account, unlock, err := api.account()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	fileName := "invoice-" + strconv.Itoa(api.fake.lastFileId+1) + ".pdf"
+	data := []byte("Счёт на " + FormatRubles(amount))
+	id := api.fake.addFile(account.Id, "invoice", fileName, time.Now(), data)
+	return account.file(id), nil
+}
+
+func (api *fakeAPI) LastFileByType(fileType string) (*deli.File, error) {
+	account, unlock, err := api.account()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var last *FakeFile
+	for _, file := range account.Files {
+		if file.Type == fileType && (last == nil || file.Date.After(last.Date)) {
+			last = file
+		}
+	}
+	if last == nil {
+		return nil, errors.New("документ не найден")
+	}
+	return account.file(last.Id), nil
+}
+
+func (api *fakeAPI) SetFiles() error {
+	account, unlock, err := api.account()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	files := make(deli.Files, 0, len(account.Files))
+	for _, file := range account.Files {
+		files = append(files, file.FileInfo)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Date.After(files[j].Date)
+	})
+	api.data.Files = files
+	return nil
+}
+
+func (api *fakeAPI) FileById(id int) (*deli.File, error) {
+	account, unlock, err := api.account()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if file := account.file(id); file != nil {
+		return file, nil
+	}
+	return nil, errors.New("документ не найден")
+}
+
+func (account *FakeAccount) file(id int) *deli.File {
+	for _, file := range account.Files {
+		if file.Id == id {
+			return &deli.File{Data: bytes.NewReader(file.Data), FileName: file.FileName, MIME: file.MIME}
+		}
+	}
+	return nil
+}
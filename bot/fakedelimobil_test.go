@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	deli "github.com/fuksman/delimobil"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// newOfflineApp creates the app working with the fake Delimobil only, its storage can't be used.
+func newOfflineApp(t *testing.T, fake *FakeDelimobil) *App {
+	t.Helper()
+	app, err := NewApp(context.Background(), AppConfig{Environment: "test", ProjectID: "dlmbltlg-test"}, Deps{
+		Log:         NewLogger("test"),
+		Delimobil:   fake,
+		BotSettings: tele.Settings{Token: "offline", Offline: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func newFakeAcme() *FakeDelimobil {
+	fake := NewFakeDelimobil()
+	fake.AddAccount(&FakeAccount{Id: 1, Login: "acme", Password: "secret", Info: deli.Info{Name: "Acme", Balance: 1500}})
+	return fake
+}
+
+func TestFakeDelimobilScriptsCompany(t *testing.T) {
+	fake := newFakeAcme()
+	app := newOfflineApp(t, fake)
+
+	company := app.NewCompany(context.Background(), "acme", "secret")
+	if err := company.Authenticate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := company.SetInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if company.Info.Name != "Acme" || company.Info.Balance != 1500 {
+		t.Errorf("info = %+v, want Acme with 1500", company.Info)
+	}
+
+	fake.SetBalance(1, 700)
+	if err := company.SetInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if company.Info.Balance != 700 {
+		t.Errorf("balance = %v, want 700", company.Info.Balance)
+	}
+
+	invoice, err := company.CreateInvoice(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := company.LastFileByType("invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.FileName != invoice.FileName {
+		t.Errorf("last invoice = %q, want %q", last.FileName, invoice.FileName)
+	}
+}
+
+func TestFakeDelimobilRejectsCredentials(t *testing.T) {
+	fake := newFakeAcme()
+	app := newOfflineApp(t, fake)
+
+	if err := app.NewCompany(context.Background(), "acme", "wrong").Authenticate(); !errors.Is(err, ErrDelimobilUnauthorized) {
+		t.Errorf("wrong password: err = %v, want %v", err, ErrDelimobilUnauthorized)
+	}
+
+	company := app.NewCompany(context.Background(), "acme", "secret")
+	fake.SetPassword(1, "changed")
+	if err := company.Authenticate(); !errors.Is(err, ErrDelimobilUnauthorized) {
+		t.Errorf("changed password: err = %v, want %v", err, ErrDelimobilUnauthorized)
+	}
+	if err := app.NewCompany(context.Background(), "acme", "changed").Authenticate(); err != nil {
+		t.Errorf("new password: err = %v", err)
+	}
+}
+
+func TestFakeDelimobilErrors(t *testing.T) {
+	fake := newFakeAcme()
+	app := newOfflineApp(t, fake)
+	company := app.NewCompany(context.Background(), "acme", "secret")
+
+	failure := errors.New("внутренняя ошибка")
+	fake.SetError(1, failure)
+	if err := company.SetInfo(); !errors.Is(err, failure) {
+		t.Errorf("err = %v, want %v as is", err, failure)
+	}
+
+	// Transient failures are retried and reported as unavailability
+	fake.SetError(1, io.ErrUnexpectedEOF)
+	if err := company.SetInfo(); !errors.Is(err, ErrDelimobilUnavailable) {
+		t.Errorf("err = %v, want %v", err, ErrDelimobilUnavailable)
+	}
+
+	fake.SetError(1, nil)
+	if err := company.SetInfo(); err != nil {
+		t.Errorf("err = %v after the failure is gone", err)
+	}
+}
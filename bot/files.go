@@ -0,0 +1,254 @@
+package bot
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"path"
+	"strconv"
+	"strings"
+
+	deli "github.com/fuksman/delimobil"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+func (app *App) Invoice(tlg *tele.Context, amount ...float64) error {
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+
+	userLogger := app.log.WithField("userId", user.Id)
+
+	if amount == nil {
+		userLogger.Trace("Retrieving last invoice...")
+		err := app.SendCachedDocument(tlg, lastInvoiceKey(company.Id), app.lastInvoiceCacheTTL(), func() (*deli.File, error) {
+			return company.LastFileByType("invoice")
+		}, menu)
+		if err != nil {
+			userLogger.Warn("Can't retrieve invoice.")
+			return (*tlg).Send(err.Error())
+		}
+		userLogger.Info("Recieved invoice.")
+		return nil
+	}
+
+	record, err := app.CreateInvoice(tlg, amount[0])
+	if record == nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	return err
+}
+
+// CreateInvoice creates the invoice and sends it to the chat. The record is returned if the invoice is created,
+// even if it isn't sent, the error is not shown to the user.
+func (app *App) CreateInvoice(tlg *tele.Context, amount float64) (*InvoiceRecord, error) {
+	ctx := UpdateContext(*tlg)
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return nil, err
+	}
+	userLogger := app.log.WithField("userId", user.Id)
+
+	if err := company.SetInfo(); err != nil {
+		return nil, err
+	}
+	userLogger.Trace("Creating new invoice...")
+	invoice, err := company.CreateInvoice(amount)
+	if err != nil {
+		userLogger.Warn("Can't create invoice.")
+		return nil, err
+	}
+	userLogger.Info("Created invoice.")
+	app.RemoveDocumentRef(ctx, lastInvoiceKey(company.Id))
+	record := NewInvoiceRecord(company, user, amount, invoice.FileName)
+	attachment, err := ReadAttachment(invoice)
+	if err != nil {
+		userLogger.Warn(err)
+		app.SaveInvoiceRecord(ctx, record)
+		return record, err
+	}
+	app.background(func(ctx context.Context) {
+		app.EmailDocuments(ctx, company, "Счёт Делимобиль на "+FormatRubles(amount), []*Attachment{attachment})
+	})
+
+	doc := &tele.Document{File: tele.FromReader(attachment.File().Data)}
+	doc.FileName = invoice.FileName
+	doc.MIME = invoice.MIME
+	msg, err := (*tlg).Bot().Send((*tlg).Recipient(), doc, menu)
+	if err == nil && msg.Document != nil {
+		// Telegram file id allows to send the invoice again without Delimobil
+		record.FileId = msg.Document.FileID
+	}
+	app.SaveInvoiceRecord(ctx, record)
+	return record, err
+}
+
+func (app *App) LastClosingDocuments(tlg *tele.Context) error {
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+
+	// New closing documents are emailed by the notifier, no matter if anyone requests them
+	userLogger := app.log.WithField("userId", user.Id)
+	for _, fileType := range closingDocumentTypes {
+		fileType := fileType
+		userLogger.Trace("Retrieving last " + fileType + "...")
+		err := app.SendCachedDocument(tlg, strconv.Itoa(company.Id)+"-last-"+fileType, app.documentCacheTTL(), func() (*deli.File, error) {
+			return company.LastFileByType(fileType)
+		}, menu)
+		if err != nil {
+			userLogger.Warn("Can't retrieve last " + fileType + ".")
+			return (*tlg).Send(err.Error())
+		}
+		userLogger.Info("Recieved " + fileType + ".")
+	}
+	return nil
+}
+
+func (app *App) ExportRides(tlg *tele.Context, format string, period Period) error {
+	ctx := UpdateContext(*tlg)
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+
+	userLogger := app.log.WithField("userId", user.Id)
+	userLogger.Trace("Exporting rides...")
+	rides, err := app.RidesForPeriod(company, period)
+	if err != nil {
+		userLogger.Warn("Can't retrieve rides.")
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if len(rides) == 0 {
+		return (*tlg).Send("Нет поездок за "+period.String(), menu)
+	}
+	tags, err := app.LoadRideTags(ctx, company.Id)
+	if err != nil {
+		userLogger.Warn("Can't retrieve ride tags.")
+		return (*tlg).Send(err.Error(), menu)
+	}
+
+	var (
+		export *deli.File
+		table  = RidesTable(rides, tags, "rides-"+period.From.Format("2006-01"))
+	)
+	switch format {
+	case "csv":
+		export, err = table.CSV()
+	case "xlsx":
+		export, err = table.XLSX()
+	default:
+		return (*tlg).Send("Формат выгрузки должен быть csv или xlsx", menu)
+	}
+	if err != nil {
+		userLogger.Warn(err)
+		return (*tlg).Send(err.Error(), menu)
+	}
+	userLogger.Info("Exported rides.")
+	doc := &tele.Document{File: tele.FromReader(export.Data)}
+	doc.FileName = export.FileName
+	doc.MIME = export.MIME
+	return (*tlg).Send(doc, menu)
+}
+
+// closingDocumentTypes are sent as closing documents of the period
+var closingDocumentTypes = []string{"upd", "rentsDetail"}
+
+// ClosingDocumentsZip sends all closing documents of the period as one ZIP archive.
+// The archive is built only if every document is fetched, otherwise the failures are reported.
+func (app *App) ClosingDocumentsZip(tlg *tele.Context, period Period) error {
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+
+	userLogger := app.log.WithField("userId", user.Id)
+	userLogger.Trace("Collecting closing documents for " + period.String() + "...")
+	if err := company.SetFiles(); err != nil {
+		userLogger.Warn(err)
+		return (*tlg).Send(err.Error(), menu)
+	}
+
+	var (
+		attachments []*Attachment
+		failures    []string
+	)
+	for _, info := range company.Files {
+		if !period.Contains(info.Date) || !containsString(closingDocumentTypes, info.Type) {
+			continue
+		}
+		file, err := company.FileById(info.Id)
+		if err == nil {
+			var attachment *Attachment
+			if attachment, err = ReadAttachment(file); err == nil {
+				attachments = append(attachments, attachment)
+				continue
+			}
+		}
+		userLogger.Warn("Can't retrieve " + info.FileName + ": " + err.Error())
+		failures = append(failures, documentTypeTitle(info.Type)+" "+info.FileName+": "+err.Error())
+	}
+
+	if len(failures) > 0 {
+		mes := "❌ Не собрал архив за " + period.String() + ", часть документов не получена:\n" + strings.Join(failures, "\n")
+		if len(attachments) > 0 {
+			received := make([]string, 0, len(attachments))
+			for _, attachment := range attachments {
+				received = append(received, attachment.FileName)
+			}
+			mes += "\n\nПолучены:\n" + strings.Join(received, "\n")
+		}
+		return (*tlg).Send(mes+"\n\nПопробуй ещё раз позже", menu)
+	}
+	if len(attachments) == 0 {
+		return (*tlg).Send("Нет закрывающих документов за "+period.String(), menu)
+	}
+
+	archive, err := ZipAttachments("closing-"+period.From.Format("2006-01")+".zip", attachments)
+	if err != nil {
+		userLogger.Warn(err)
+		return (*tlg).Send(err.Error(), menu)
+	}
+	userLogger.Info("Collected closing documents.")
+	doc := &tele.Document{File: tele.FromReader(archive.Data)}
+	doc.FileName = archive.FileName
+	doc.MIME = archive.MIME
+	return (*tlg).Send(doc, menu)
+}
+
+func ZipAttachments(name string, attachments []*Attachment) (*deli.File, error) {
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	used := make(map[string]bool)
+	for _, attachment := range attachments {
+		// Several documents of the period may have the same name, a numbered name may be taken too
+		fileName := attachment.FileName
+		ext := path.Ext(fileName)
+		for i := 2; used[fileName]; i++ {
+			fileName = strings.TrimSuffix(attachment.FileName, ext) + "-" + strconv.Itoa(i) + ext
+		}
+		used[fileName] = true
+		w, err := zw.Create(fileName)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(attachment.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &deli.File{Data: &buf, FileName: name, MIME: "application/zip"}, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestZipAttachments(t *testing.T) {
+	file, err := ZipAttachments("documents-2021-10.zip", []*Attachment{
+		{FileName: "act.pdf", Data: []byte("Акт 1")},
+		{FileName: "act.pdf", Data: []byte("Акт 2")},
+		{FileName: "act-2.pdf", Data: []byte("Акт 3")},
+		{FileName: "invoice", Data: []byte("Счёт-фактура 1")},
+		{FileName: "invoice", Data: []byte("Счёт-фактура 2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.FileName != "documents-2021-10.zip" || file.MIME != "application/zip" {
+		t.Errorf("file = %s, %s", file.FileName, file.MIME)
+	}
+
+	data, err := io.ReadAll(file.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ name, data string }{
+		{"act.pdf", "Акт 1"},
+		{"act-2.pdf", "Акт 2"},
+		{"act-2-2.pdf", "Акт 3"},
+		{"invoice", "Счёт-фактура 1"},
+		{"invoice-2", "Счёт-фактура 2"},
+	}
+	if len(zr.File) != len(want) {
+		t.Fatalf("%d files in the archive, want %d", len(zr.File), len(want))
+	}
+	for i, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name != want[i].name || string(content) != want[i].data {
+			t.Errorf("file %d = %s %q, want %s %q", i, f.Name, content, want[i].name, want[i].data)
+		}
+	}
+}
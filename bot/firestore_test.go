@@ -0,0 +1,341 @@
+package bot
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeFirestore is the in-memory Firestore serving the calls the app makes:
+// gets, writes with preconditions, transactions, simple queries and listing of documents.
+// Transactions are optimistic: the commit is aborted if a document read has changed since.
+type fakeFirestore struct {
+	pb.UnimplementedFirestoreServer
+
+	mu   sync.Mutex
+	docs map[string]*pb.Document
+	// Documents read by the transactions with their update times, zero for missing ones
+	reads    map[string]map[string]time.Time
+	lastTx   int
+	lastTime time.Time
+}
+
+// newFakeFirestore starts the fake on a local port and returns the client connected to it.
+func newFakeFirestore(t *testing.T, projectID string) *firestore.Client {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterFirestoreServer(server, &fakeFirestore{docs: make(map[string]*pb.Document), reads: make(map[string]map[string]time.Time)})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := firestore.NewClient(context.Background(), projectID, option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// now returns the strictly increasing time, so every write changes the update time.
+func (fs *fakeFirestore) now() time.Time {
+	now := time.Now()
+	if !now.After(fs.lastTime) {
+		now = fs.lastTime.Add(time.Microsecond)
+	}
+	fs.lastTime = now
+	return now
+}
+
+func (fs *fakeFirestore) updateTime(name string) time.Time {
+	if doc, ok := fs.docs[name]; ok {
+		return doc.UpdateTime.AsTime()
+	}
+	return time.Time{}
+}
+
+func (fs *fakeFirestore) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.lastTx++
+	id := strconv.Itoa(fs.lastTx)
+	fs.reads[id] = make(map[string]time.Time)
+	return &pb.BeginTransactionResponse{Transaction: []byte(id)}, nil
+}
+
+func (fs *fakeFirestore) Rollback(ctx context.Context, req *pb.RollbackRequest) (*emptypb.Empty, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.reads, string(req.Transaction))
+	return &emptypb.Empty{}, nil
+}
+
+func (fs *fakeFirestore) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	fs.mu.Lock()
+	reads := fs.reads[string(req.GetTransaction())]
+	var responses []*pb.BatchGetDocumentsResponse
+	readTime := timestamppb.New(fs.now())
+	for _, name := range req.Documents {
+		if reads != nil {
+			reads[name] = fs.updateTime(name)
+		}
+		response := &pb.BatchGetDocumentsResponse{ReadTime: readTime}
+		if doc, ok := fs.docs[name]; ok {
+			response.Result = &pb.BatchGetDocumentsResponse_Found{Found: proto.Clone(doc).(*pb.Document)}
+		} else {
+			response.Result = &pb.BatchGetDocumentsResponse_Missing{Missing: name}
+		}
+		responses = append(responses, response)
+	}
+	fs.mu.Unlock()
+
+	for _, response := range responses {
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *fakeFirestore) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if req.Transaction != nil {
+		reads, ok := fs.reads[string(req.Transaction)]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown transaction %q", req.Transaction)
+		}
+		delete(fs.reads, string(req.Transaction))
+		for name, updateTime := range reads {
+			if !fs.updateTime(name).Equal(updateTime) {
+				return nil, status.Errorf(codes.Aborted, "%q has changed since the transaction read it", name)
+			}
+		}
+	}
+
+	// All the preconditions are checked before any write is applied
+	for _, write := range req.Writes {
+		name := write.GetDelete()
+		if write.GetUpdate() != nil {
+			name = write.GetUpdate().Name
+		}
+		_, exists := fs.docs[name]
+		switch condition := write.CurrentDocument.GetConditionType().(type) {
+		case *pb.Precondition_Exists:
+			if condition.Exists && !exists {
+				return nil, status.Errorf(codes.NotFound, "no entity to update: %q", name)
+			}
+			if !condition.Exists && exists {
+				return nil, status.Errorf(codes.AlreadyExists, "entity already exists: %q", name)
+			}
+		case *pb.Precondition_UpdateTime:
+			if !fs.updateTime(name).Equal(condition.UpdateTime.AsTime()) {
+				return nil, status.Errorf(codes.FailedPrecondition, "%q has another update time", name)
+			}
+		}
+	}
+
+	commitTime := timestamppb.New(fs.now())
+	response := &pb.CommitResponse{CommitTime: commitTime}
+	for _, write := range req.Writes {
+		if name := write.GetDelete(); name != "" {
+			delete(fs.docs, name)
+		} else if update := write.GetUpdate(); update != nil {
+			doc := &pb.Document{Name: update.Name, Fields: make(map[string]*pb.Value), CreateTime: commitTime, UpdateTime: commitTime}
+			if saved, ok := fs.docs[update.Name]; ok {
+				doc.CreateTime = saved.CreateTime
+				if write.UpdateMask != nil {
+					doc.Fields = saved.Fields
+				}
+			}
+			if write.UpdateMask == nil {
+				doc.Fields = update.Fields
+			} else {
+				for _, path := range write.UpdateMask.FieldPaths {
+					if value, ok := update.Fields[path]; ok {
+						doc.Fields[path] = value
+					} else {
+						delete(doc.Fields, path)
+					}
+				}
+			}
+			fs.docs[update.Name] = proto.Clone(doc).(*pb.Document)
+		}
+		response.WriteResults = append(response.WriteResults, &pb.WriteResult{UpdateTime: commitTime})
+	}
+	return response, nil
+}
+
+func (fs *fakeFirestore) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	query := req.GetStructuredQuery()
+	if query == nil || len(query.From) != 1 || query.From[0].AllDescendants {
+		return status.Error(codes.Unimplemented, "only queries of a single collection are supported")
+	}
+	prefix := req.Parent + "/" + query.From[0].CollectionId + "/"
+
+	fs.mu.Lock()
+	reads := fs.reads[string(req.GetTransaction())]
+	var docs []*pb.Document
+	for name, doc := range fs.docs {
+		if !strings.HasPrefix(name, prefix) || strings.Contains(strings.TrimPrefix(name, prefix), "/") {
+			continue
+		}
+		match, err := matchFilter(doc, query.Where)
+		if err != nil {
+			fs.mu.Unlock()
+			return err
+		}
+		if match {
+			docs = append(docs, proto.Clone(doc).(*pb.Document))
+		}
+	}
+	readTime := timestamppb.New(fs.now())
+	fs.mu.Unlock()
+
+	sort.Slice(docs, func(i, j int) bool {
+		for _, order := range query.OrderBy {
+			c := compareValues(docs[i].Fields[order.Field.FieldPath], docs[j].Fields[order.Field.FieldPath])
+			if order.Direction == pb.StructuredQuery_DESCENDING {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return docs[i].Name < docs[j].Name
+	})
+	if query.Limit != nil && int(query.Limit.Value) < len(docs) {
+		docs = docs[:query.Limit.Value]
+	}
+
+	for _, doc := range docs {
+		if reads != nil {
+			fs.mu.Lock()
+			reads[doc.Name] = doc.UpdateTime.AsTime()
+			fs.mu.Unlock()
+		}
+		if err := stream.Send(&pb.RunQueryResponse{Document: doc, ReadTime: readTime}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *fakeFirestore) ListDocuments(ctx context.Context, req *pb.ListDocumentsRequest) (*pb.ListDocumentsResponse, error) {
+	prefix := req.Parent + "/" + req.CollectionId + "/"
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	response := &pb.ListDocumentsResponse{}
+	for name := range fs.docs {
+		if strings.HasPrefix(name, prefix) && !strings.Contains(strings.TrimPrefix(name, prefix), "/") {
+			response.Documents = append(response.Documents, &pb.Document{Name: name})
+		}
+	}
+	sort.Slice(response.Documents, func(i, j int) bool { return response.Documents[i].Name < response.Documents[j].Name })
+	return response, nil
+}
+
+func matchFilter(doc *pb.Document, filter *pb.StructuredQuery_Filter) (bool, error) {
+	switch filter := filter.GetFilterType().(type) {
+	case nil:
+		return true, nil
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		for _, f := range filter.CompositeFilter.Filters {
+			if match, err := matchFilter(doc, f); err != nil || !match {
+				return false, err
+			}
+		}
+		return true, nil
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		value, ok := doc.Fields[filter.FieldFilter.Field.FieldPath]
+		if !ok {
+			return false, nil
+		}
+		c := compareValues(value, filter.FieldFilter.Value)
+		switch filter.FieldFilter.Op {
+		case pb.StructuredQuery_FieldFilter_EQUAL:
+			return c == 0, nil
+		case pb.StructuredQuery_FieldFilter_LESS_THAN:
+			return c < 0, nil
+		case pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+			return c <= 0, nil
+		case pb.StructuredQuery_FieldFilter_GREATER_THAN:
+			return c > 0, nil
+		case pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+			return c >= 0, nil
+		}
+	}
+	return false, status.Errorf(codes.Unimplemented, "filter %v is not supported", filter)
+}
+
+// compareValues orders the values of the same type, numbers are compared regardless of their type.
+func compareValues(a, b *pb.Value) int {
+	if an, ok := number(a); ok {
+		if bn, ok := number(b); ok {
+			switch {
+			case an < bn:
+				return -1
+			case an > bn:
+				return 1
+			}
+			return 0
+		}
+	}
+	switch {
+	case a.GetTimestampValue() != nil && b.GetTimestampValue() != nil:
+		at, bt := a.GetTimestampValue().AsTime(), b.GetTimestampValue().AsTime()
+		switch {
+		case at.Before(bt):
+			return -1
+		case at.After(bt):
+			return 1
+		}
+		return 0
+	case a.GetValueType() != nil && b.GetValueType() != nil:
+		if _, ok := a.GetValueType().(*pb.Value_StringValue); ok {
+			return strings.Compare(a.GetStringValue(), b.GetStringValue())
+		}
+		if _, ok := a.GetValueType().(*pb.Value_BooleanValue); ok && a.GetBooleanValue() != b.GetBooleanValue() {
+			if a.GetBooleanValue() {
+				return 1
+			}
+			return -1
+		}
+		if proto.Equal(a, b) {
+			return 0
+		}
+	}
+	return strings.Compare(a.String(), b.String())
+}
+
+func number(v *pb.Value) (float64, bool) {
+	switch value := v.GetValueType().(type) {
+	case *pb.Value_IntegerValue:
+		return float64(value.IntegerValue), true
+	case *pb.Value_DoubleValue:
+		return value.DoubleValue, true
+	}
+	return 0, false
+}
@@ -0,0 +1,252 @@
+package bot
+
+import (
+	"errors"
+	"strconv"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+func (app *App) provideUserToContext(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(tlg tele.Context) error {
+		ctx := UpdateContext(tlg)
+		user, err := app.LoadUser(ctx, tlg.Sender().ID)
+		if err != nil {
+			return tlg.Send(err.Error())
+		}
+
+		tlg.Set("user", user)
+		return next(tlg)
+	}
+}
+
+func (app *App) provideCompanyToContext(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(tlg tele.Context) error {
+		ctx := UpdateContext(tlg)
+		user, ok := tlg.Get("user").(*User)
+		if !ok {
+			err := errors.New("ошибка получения информации о пользователе")
+			app.log.Warn(err)
+			return tlg.Send(err.Error())
+		}
+		company, err := app.LoadCompany(ctx, user.CompanyId)
+		if err != nil {
+			return tlg.Send(err.Error())
+		}
+
+		permissions := app.Permissions(company, user)
+		tlg.Set("company", company)
+		tlg.Set("permissions", permissions)
+
+		switch permissions {
+		case "admin":
+			tlg.Set("menu", app.adminMenu)
+			return next(tlg)
+		case "employee":
+			tlg.Set("menu", app.emplMenu)
+			return next(tlg)
+		default:
+			return tlg.Send("Не могу найти компаний, к которым у тебя есть доступ", app.startMenu)
+		}
+	}
+}
+
+func (app *App) ensureIsAdmin(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(tlg tele.Context) error {
+		permissions, ok := tlg.Get("permissions").(string)
+		if !ok {
+			err := errors.New("ошибка получения информации о правах доступа")
+			app.log.Warn(err)
+			return tlg.Send(err.Error())
+		}
+
+		if permissions == "admin" {
+			return next(tlg)
+		} else {
+			return tlg.Send("Команда доступна только администратору компании", app.emplMenu)
+		}
+	}
+}
+
+func (app *App) SignOut() tele.HandlerFunc {
+	return func(tlg tele.Context) error {
+		ctx := UpdateContext(tlg)
+		if err := app.RemoveUser(ctx, tlg.Sender().ID); err != nil {
+			app.log.Warn(err)
+			return tlg.Send(err.Error())
+		}
+
+		user, ok := tlg.Get("user").(*User)
+		if !ok {
+			err := errors.New("ошибка получения информации о пользователе")
+			app.log.Warn(err)
+			return tlg.Send(err.Error())
+		}
+		if user.Admin {
+			if err := app.RemoveCompany(ctx, user.CompanyId); err != nil {
+				app.log.Warn(err)
+				return tlg.Send(err.Error())
+			}
+			return tlg.Send("✅ Удалил всю информацию о связанной компании")
+		}
+
+		return tlg.Send("👋 Удалил всю информацию о пользователе, но всегда можно начать сначала", app.startMenu)
+	}
+}
+
+func (app *App) SendInvoiceMenu(tlg *tele.Context) error {
+	_, company, _, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	return (*tlg).Send("Какой нужен счёт?", app.InvoiceMenu(company))
+}
+
+// InvoiceMenu is built for each company from its invoice presets.
+func (app *App) InvoiceMenu(company *Company) *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	var (
+		rows []tele.Row
+		row  []tele.Btn
+	)
+	for _, amount := range company.Presets() {
+		row = append(row, menu.Data("На "+FormatRubles(amount), app.btnNewInvoicePreset.Unique, strconv.FormatFloat(amount, 'f', 2, 64)))
+		if len(row) == 2 {
+			rows = append(rows, menu.Row(row...))
+			row = nil
+		}
+	}
+	row = append(row, app.btnNewInvoiceCustom)
+	if len(row) == 2 {
+		rows = append(rows, menu.Row(row...))
+		row = nil
+	}
+	row = append(row, app.btnLastInvoice)
+	rows = append(rows, menu.Row(row...))
+	menu.Inline(rows...)
+	return menu
+}
+
+func (app *App) SendExportMenu(tlg *tele.Context) error {
+	return (*tlg).Send("За какой период нужна выгрузка?\nЛюбой другой месяц: `/export csv 2021-10` или `/export xlsx 2021-10`", app.exportMenu, tele.ModeMarkdown)
+}
+
+func (app *App) SendReportMenu(tlg *tele.Context) error {
+	return (*tlg).Send("За какой период нужен отчёт?\nЛюбой другой месяц: `/report 2021-10` или `/report 2021-10 xlsx`", app.reportMenu, tele.ModeMarkdown)
+}
+
+// Menus are the keyboards and the inline button templates handlers are registered for.
+type Menus struct {
+	startMenu, unAuthMenu, emplMenu, adminMenu               *tele.ReplyMarkup
+	exportMenu, reportMenu, cancelMenu, confirmInvoiceMenu   *tele.ReplyMarkup
+	btnNewInvoicePreset, btnLastInvoice                      tele.Btn
+	btnNewInvoiceCustom, btnConfirmInvoice, btnCancelInvoice tele.Btn
+	btnExportRides, btnSpendingReport, btnRidePurpose        tele.Btn
+	btnResendInvoice, btnMarkInvoicePaid                     tele.Btn
+	btnApproveTopUp, btnRejectTopUp                          tele.Btn
+	btnDocumentTypes, btnDocumentType                        tele.Btn
+	btnDocumentMonth, btnDocumentFile                        tele.Btn
+}
+
+func NewMenus() *Menus {
+	menus := &Menus{}
+	menus.startMenu = &tele.ReplyMarkup{ResizeKeyboard: true}
+	menus.unAuthMenu = &tele.ReplyMarkup{ResizeKeyboard: true}
+	menus.emplMenu = &tele.ReplyMarkup{ResizeKeyboard: true}
+	menus.adminMenu = &tele.ReplyMarkup{ResizeKeyboard: true}
+
+	menus.startMenu.Reply(
+		menus.startMenu.Row(menus.startMenu.Contact("Дать номер телефона")),
+	)
+
+	menus.unAuthMenu.Reply(
+		menus.unAuthMenu.Row(menus.unAuthMenu.Text("Я администратор")),
+		menus.unAuthMenu.Row(menus.unAuthMenu.Text("Разлогиниться")),
+	)
+
+	menus.emplMenu.Reply(
+		menus.emplMenu.Row(menus.emplMenu.Text("Баланс"), menus.emplMenu.Text("Поездки")),
+		menus.emplMenu.Row(menus.emplMenu.Text("Запросить пополнение")),
+		menus.emplMenu.Row(menus.emplMenu.Text("Разлогиниться")),
+	)
+
+	menus.adminMenu.Reply(
+		menus.adminMenu.Row(menus.adminMenu.Text("Баланс"), menus.adminMenu.Text("Поездки")),
+		menus.adminMenu.Row(menus.adminMenu.Text("Последний счёт"), menus.adminMenu.Text("Новый счёт"), menus.adminMenu.Text("Счета")),
+		menus.adminMenu.Row(menus.adminMenu.Text("Последние закрывающие"), menus.adminMenu.Text("Документы")),
+		menus.adminMenu.Row(menus.adminMenu.Text("Закрывающие ZIP"), menus.adminMenu.Text("Выгрузка поездок")),
+		menus.adminMenu.Row(menus.adminMenu.Text("Отчёт по сотрудникам"), menus.adminMenu.Text("Лимиты")),
+		menus.adminMenu.Row(menus.adminMenu.Text("Цели поездок"), menus.adminMenu.Text("Сверка детализации")),
+		menus.adminMenu.Row(menus.adminMenu.Text("Разлогиниться")),
+	)
+
+	menus.btnNewInvoicePreset = (&tele.ReplyMarkup{}).Data("", "btnNewInvoicePreset")
+	menus.btnLastInvoice = (&tele.ReplyMarkup{}).Data("Последний", "btnLastInvoice")
+	menus.btnNewInvoiceCustom = (&tele.ReplyMarkup{}).Data("Другая сумма", "btnNewInvoiceCustom")
+
+	menus.cancelMenu = &tele.ReplyMarkup{ResizeKeyboard: true}
+	menus.cancelMenu.Reply(
+		menus.cancelMenu.Row(menus.cancelMenu.Text("Отмена")),
+	)
+
+	menus.confirmInvoiceMenu = &tele.ReplyMarkup{}
+	menus.btnConfirmInvoice = menus.confirmInvoiceMenu.Data("Создать", "btnConfirmInvoice")
+	menus.btnCancelInvoice = menus.confirmInvoiceMenu.Data("Отмена", "btnCancelInvoice")
+	menus.confirmInvoiceMenu.Inline(
+		menus.confirmInvoiceMenu.Row(menus.btnConfirmInvoice, menus.btnCancelInvoice),
+	)
+
+	menus.exportMenu = &tele.ReplyMarkup{}
+	menus.btnExportRides = menus.exportMenu.Data("CSV за этот месяц", "btnExportRides", "csv", "current")
+	menus.exportMenu.Inline(
+		menus.exportMenu.Row(menus.btnExportRides, menus.exportMenu.Data("XLSX за этот месяц", "btnExportRides", "xlsx", "current")),
+		menus.exportMenu.Row(
+			menus.exportMenu.Data("CSV за прошлый месяц", "btnExportRides", "csv", "previous"),
+			menus.exportMenu.Data("XLSX за прошлый месяц", "btnExportRides", "xlsx", "previous"),
+		),
+	)
+
+	menus.btnRidePurpose = (&tele.ReplyMarkup{}).Data("", "btnRidePurpose")
+	menus.btnResendInvoice = (&tele.ReplyMarkup{}).Data("", "btnResendInvoice")
+	menus.btnMarkInvoicePaid = (&tele.ReplyMarkup{}).Data("", "btnMarkInvoicePaid")
+	menus.btnApproveTopUp = (&tele.ReplyMarkup{}).Data("", "btnApproveTopUp")
+	menus.btnRejectTopUp = (&tele.ReplyMarkup{}).Data("", "btnRejectTopUp")
+
+	menus.btnDocumentTypes = (&tele.ReplyMarkup{}).Data("« К типам документов", "btnDocumentTypes")
+	menus.btnDocumentType = (&tele.ReplyMarkup{}).Data("", "btnDocumentType")
+	menus.btnDocumentMonth = (&tele.ReplyMarkup{}).Data("", "btnDocumentMonth")
+	menus.btnDocumentFile = (&tele.ReplyMarkup{}).Data("", "btnDocumentFile")
+
+	menus.reportMenu = &tele.ReplyMarkup{}
+	menus.btnSpendingReport = menus.reportMenu.Data("За этот месяц", "btnSpendingReport", "current", "")
+	menus.reportMenu.Inline(
+		menus.reportMenu.Row(menus.btnSpendingReport, menus.reportMenu.Data("За прошлый месяц", "btnSpendingReport", "previous", "")),
+		menus.reportMenu.Row(
+			menus.reportMenu.Data("XLSX за этот месяц", "btnSpendingReport", "current", "xlsx"),
+			menus.reportMenu.Data("XLSX за прошлый месяц", "btnSpendingReport", "previous", "xlsx"),
+		),
+	)
+	return menus
+}
+
+func ReadContext(tlg tele.Context) (*User, *Company, *tele.ReplyMarkup, error) {
+	user, ok := tlg.Get("user").(*User)
+	if !ok {
+		err := errors.New("ошибка получения информации о пользователе")
+		return nil, nil, nil, err
+	}
+
+	company, ok := tlg.Get("company").(*Company)
+	if !ok {
+		err := errors.New("ошибка получения информации о компании")
+		return nil, nil, nil, err
+	}
+
+	menu, ok := tlg.Get("menu").(*tele.ReplyMarkup)
+	if !ok {
+		err := errors.New("ошибка получения информации о меню")
+		return nil, nil, nil, err
+	}
+
+	return user, company, menu, nil
+}
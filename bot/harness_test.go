@@ -0,0 +1,344 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// SentMessage is a request the bot made to Telegram.
+type SentMessage struct {
+	Method    string
+	ChatId    int64
+	MessageId int
+	// Text or caption of the message
+	Text string
+	// File name of the sent document
+	Document string
+	Markup   *tele.ReplyMarkup
+	Params   map[string]string
+}
+
+// FakeTelegram is the Bot API transport which records requests and answers them successfully.
+type FakeTelegram struct {
+	mu            sync.Mutex
+	sent          []*SentMessage
+	lastMessageId int
+	// Names of the documents by their file ids
+	files map[string]string
+	// Chats of the users who have blocked the bot
+	blocked map[int64]bool
+}
+
+func NewFakeTelegram() *FakeTelegram {
+	return &FakeTelegram{files: make(map[string]string), blocked: make(map[int64]bool)}
+}
+
+// Block makes sending to the chat fail as if the user has blocked the bot, or unblocks it.
+func (telegram *FakeTelegram) Block(chatId int64, blocked bool) {
+	telegram.mu.Lock()
+	defer telegram.mu.Unlock()
+	telegram.blocked[chatId] = blocked
+}
+
+func (telegram *FakeTelegram) nextMessageId() int {
+	telegram.mu.Lock()
+	defer telegram.mu.Unlock()
+	telegram.lastMessageId++
+	return telegram.lastMessageId
+}
+
+// readParams reads JSON or multipart request of the bot, the name of uploaded file is returned separately.
+func readParams(r *http.Request) (params map[string]string, fileName string, err error) {
+	params = make(map[string]string)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		var raw map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			return nil, "", err
+		}
+		for key, val := range raw {
+			if s, ok := val.(string); ok {
+				params[key] = s
+				continue
+			}
+			data, _ := json.Marshal(val)
+			params[key] = string(data)
+		}
+		return params, "", nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return params, fileName, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FileName() != "" {
+			fileName = part.FileName()
+		} else {
+			params[part.FormName()] = string(data)
+		}
+	}
+}
+
+func (telegram *FakeTelegram) RoundTrip(r *http.Request) (*http.Response, error) {
+	params, fileName, err := readParams(r)
+	if err != nil {
+		return nil, err
+	}
+	sent := &SentMessage{Method: path.Base(r.URL.Path), Params: params, Text: params["text"]}
+	if sent.Text == "" {
+		sent.Text = params["caption"]
+	}
+	sent.ChatId, _ = strconv.ParseInt(params["chat_id"], 10, 64)
+	telegram.mu.Lock()
+	blocked := telegram.blocked[sent.ChatId]
+	telegram.mu.Unlock()
+	if blocked {
+		return telegram.respond(r, map[string]interface{}{
+			"ok":          false,
+			"error_code":  http.StatusForbidden,
+			"description": tele.ErrBlockedByUser.Description,
+		})
+	}
+	if markup := params["reply_markup"]; markup != "" {
+		sent.Markup = &tele.ReplyMarkup{}
+		json.Unmarshal([]byte(markup), sent.Markup)
+	}
+	if id, err := strconv.Atoi(params["message_id"]); err == nil {
+		sent.MessageId = id
+	} else {
+		sent.MessageId = telegram.nextMessageId()
+	}
+
+	result := map[string]interface{}{
+		"message_id": sent.MessageId,
+		"date":       time.Now().Unix(),
+		"chat":       map[string]interface{}{"id": sent.ChatId, "type": "private"},
+		"text":       sent.Text,
+	}
+	if sent.Method == "sendDocument" {
+		telegram.mu.Lock()
+		if fileName != "" {
+			fileId := "file-" + strconv.Itoa(len(telegram.files)+1)
+			telegram.files[fileId] = fileName
+			params["document"] = fileId
+		}
+		sent.Document = telegram.files[params["document"]]
+		telegram.mu.Unlock()
+		result["document"] = map[string]interface{}{"file_id": params["document"], "file_name": sent.Document}
+	}
+
+	telegram.mu.Lock()
+	telegram.sent = append(telegram.sent, sent)
+	telegram.mu.Unlock()
+
+	return telegram.respond(r, map[string]interface{}{"ok": true, "result": result})
+}
+
+func (telegram *FakeTelegram) respond(r *http.Request, response map[string]interface{}) (*http.Response, error) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	status := http.StatusOK
+	if code, ok := response["error_code"].(int); ok {
+		status = code
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+// Sent returns messages sent to the chat, including edits, in order.
+func (telegram *FakeTelegram) Sent(chatId int64) (sent []*SentMessage) {
+	telegram.mu.Lock()
+	defer telegram.mu.Unlock()
+	for _, message := range telegram.sent {
+		if message.ChatId == chatId {
+			sent = append(sent, message)
+		}
+	}
+	return sent
+}
+
+// Reset forgets all the requests recorded.
+func (telegram *FakeTelegram) Reset() {
+	telegram.mu.Lock()
+	defer telegram.mu.Unlock()
+	telegram.sent = nil
+}
+
+// Harness runs the app against fake Telegram and Delimobil.
+// Synthetic updates go through the real handlers and middleware one at a time,
+// so after each of them everything the bot has sent is already recorded.
+type Harness struct {
+	App       *App
+	Telegram  *FakeTelegram
+	Delimobil *FakeDelimobil
+
+	mu           sync.Mutex
+	lastUpdateId int
+}
+
+// newTestHarness creates the harness with the storage of the in-process fake Firestore,
+// or of Firestore emulator when FIRESTORE_EMULATOR_HOST is set.
+// Every harness gets its own root collection, so tests don't see the data of each other.
+func newTestHarness(t *testing.T) *Harness {
+	t.Helper()
+	ctx := context.Background()
+	var storage *firestore.Client
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") != "" {
+		var err error
+		if storage, err = firestore.NewClient(ctx, "dlmbltlg-test"); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { storage.Close() })
+	} else {
+		storage = newFakeFirestore(t, "dlmbltlg-test")
+	}
+	h, err := NewHarness(ctx, AppConfig{
+		Environment:      "test",
+		ProjectID:        "harness-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		CheckDelay:       1,
+		ReminderDays:     defaultReminderDays,
+		DocumentCacheTTL: defaultDocumentCacheTTL,
+	}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// NewHarness creates the app with the storage given, e.g. the fake Firestore or the emulator.
+func NewHarness(ctx context.Context, config AppConfig, storage *firestore.Client) (*Harness, error) {
+	telegram := NewFakeTelegram()
+	delimobil := NewFakeDelimobil()
+	app, err := NewApp(ctx, config, Deps{
+		Log:       NewLogger(config.Environment),
+		Storage:   storage,
+		Delimobil: delimobil,
+		BotSettings: tele.Settings{
+			Token:       "harness",
+			Offline:     true,
+			Synchronous: true,
+			Client:      &http.Client{Transport: telegram},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Queued messages are sent right after each update, without waiting for Telegram limits
+	app.outbox.globalInterval = 0
+	app.outbox.chatInterval = 0
+	return &Harness{App: app, Telegram: telegram, Delimobil: delimobil}, nil
+}
+
+// process handles the update and waits for the work it started in background and the messages it queued.
+func (h *Harness) process(update tele.Update) {
+	h.mu.Lock()
+	h.lastUpdateId++
+	update.ID = h.lastUpdateId
+	h.mu.Unlock()
+	h.App.bot.ProcessUpdate(update)
+	h.App.inFlight.Wait()
+	h.App.outbox.Flush(h.App.ctx)
+}
+
+func (h *Harness) message(userId int64) *tele.Message {
+	return &tele.Message{
+		ID:       h.Telegram.nextMessageId(),
+		Unixtime: time.Now().Unix(),
+		Sender:   &tele.User{ID: userId},
+		Chat:     &tele.Chat{ID: userId, Type: tele.ChatPrivate},
+	}
+}
+
+// SendText sends a text or a command from the user.
+func (h *Harness) SendText(userId int64, text string) {
+	message := h.message(userId)
+	message.Text = text
+	h.process(tele.Update{Message: message})
+}
+
+// SendContact shares the contact of the user with the phone.
+func (h *Harness) SendContact(userId int64, phone string) {
+	message := h.message(userId)
+	message.Contact = &tele.Contact{PhoneNumber: phone, UserID: int(userId)}
+	h.process(tele.Update{Message: message})
+}
+
+// Press presses the inline button with the text in the latest message to the user having it.
+func (h *Harness) Press(userId int64, text string) error {
+	sent := h.Telegram.Sent(userId)
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].Markup == nil {
+			continue
+		}
+		for _, row := range sent[i].Markup.InlineKeyboard {
+			for _, button := range row {
+				if button.Text != text {
+					continue
+				}
+				message := h.message(userId)
+				message.ID = sent[i].MessageId
+				message.Text = sent[i].Text
+				h.process(tele.Update{Callback: &tele.Callback{
+					ID:      strconv.Itoa(h.Telegram.nextMessageId()),
+					Sender:  message.Sender,
+					Message: message,
+					Data:    button.Data,
+				}})
+				return nil
+			}
+		}
+	}
+	return errors.New("no button " + text)
+}
+
+// Notify runs a single tick of the notifiers and sends the notifications queued.
+func (h *Harness) Notify() {
+	h.App.NotifierTick(h.App.outbox, h.App.notifiers())
+	h.App.outbox.Flush(h.App.ctx)
+}
+
+// Texts returns texts of the messages sent to the chat, one per line, to compare conversations at once.
+func (h *Harness) Texts(chatId int64) string {
+	var texts []string
+	for _, sent := range h.Telegram.Sent(chatId) {
+		if sent.Document != "" {
+			texts = append(texts, strings.TrimSpace("["+sent.Document+"] "+sent.Text))
+		} else if sent.Text != "" {
+			texts = append(texts, sent.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
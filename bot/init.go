@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+type AppConfig struct {
+	Environment      string            `json:"environment"`
+	TelegramToken    string            `json:"telegram_token"`
+	ProjectID        string            `json:"project_id"`
+	CheckDelay       int               `json:"check_delay"`
+	ReminderDays     int               `json:"reminder_days"`
+	DocumentCacheTTL int               `json:"document_cache_ttl"`
+	SMTP             *SMTPConfig       `json:"smtp"`
+	Webhook          *WebhookConfig    `json:"webhook"`
+	Monitoring       *MonitoringConfig `json:"monitoring"`
+}
+
+const (
+	defaultReminderDays     = 3
+	defaultDocumentCacheTTL = 3600
+)
+
+func (appConfig *AppConfig) LoadConfiguration() error {
+	file := os.Getenv("DLMBLTLG")
+	if file == "" {
+		return errors.New("should be DLMBLTLG environment variable set to the path to app config")
+	}
+	configFile, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer configFile.Close()
+	jsonParser := json.NewDecoder(configFile)
+	jsonParser.Decode(&appConfig)
+	if appConfig.ReminderDays <= 0 {
+		appConfig.ReminderDays = defaultReminderDays
+	}
+	if appConfig.DocumentCacheTTL <= 0 {
+		appConfig.DocumentCacheTTL = defaultDocumentCacheTTL
+	}
+	if appConfig.SMTP != nil && (appConfig.SMTP.Host == "" || appConfig.SMTP.Port <= 0 || appConfig.SMTP.From == "") {
+		return errors.New("'smtp' requires 'host', 'port' and 'from' fields")
+	}
+	if appConfig.Webhook != nil {
+		if appConfig.Webhook.Listen == "" || appConfig.Webhook.PublicURL == "" {
+			return errors.New("'webhook' requires 'listen' and 'public_url' fields")
+		}
+		if (appConfig.Webhook.TLSCert == "") != (appConfig.Webhook.TLSKey == "") {
+			return errors.New("'webhook' requires both 'tls_cert' and 'tls_key' or none of them")
+		}
+	}
+	if appConfig.Monitoring != nil && appConfig.Monitoring.Listen == "" {
+		return errors.New("'monitoring' requires 'listen' field")
+	}
+	if appConfig.Environment != "test" && appConfig.Environment != "prod" {
+		return errors.New("'environment' should be 'test' or 'prod', but now it is " + appConfig.Environment)
+	}
+	if appConfig.CheckDelay <= 0 {
+		return errors.New("all fields of the configutation file are required")
+	}
+	for _, val := range []string{appConfig.Environment, appConfig.TelegramToken, appConfig.ProjectID} {
+		if val == "" {
+			return errors.New("all fields of the configutation file are required")
+		}
+	}
+	return nil
+}
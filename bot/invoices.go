@@ -0,0 +1,396 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+const (
+	minInvoiceAmount = 1000
+	maxInvoiceAmount = 1000000
+
+	maxInvoicePresets = 6
+
+	// A top-up pays the invoice if it differs from the invoice amount less than this share,
+	// as rides may be charged between two balance checks.
+	paymentTolerance = 0.1
+)
+
+var defaultInvoicePresets = []float64{3000, 10000, 30000}
+
+// FormatRubles formats amount like "10 000 ₽", kopecks are shown only if there are any.
+func FormatRubles(amount float64) string {
+	// Rounded to kopecks first, so 999.999 is 1 000 ₽ and not 999 ₽
+	kopecks := int64(math.Round(math.Abs(amount) * 100))
+	rubles := strconv.FormatInt(kopecks/100, 10)
+	for i := len(rubles) - 3; i > 0; i -= 3 {
+		rubles = rubles[:i] + " " + rubles[i:]
+	}
+	if kopecks%100 != 0 {
+		rubles += "," + strconv.FormatInt(kopecks%100+100, 10)[1:]
+	}
+	if amount < 0 && kopecks != 0 {
+		rubles = "-" + rubles
+	}
+	return rubles + " ₽"
+}
+
+// Presets returns amounts of the invoice buttons of the company.
+func (company *Company) Presets() []float64 {
+	if len(company.InvoicePresets) == 0 {
+		return defaultInvoicePresets
+	}
+	return company.InvoicePresets
+}
+
+func (app *App) SetPresets(ctx context.Context, company *Company, amounts []string) error {
+	if len(amounts) > maxInvoicePresets {
+		return errors.New("можно задать не больше " + strconv.Itoa(maxInvoicePresets) + " сумм")
+	}
+	presets := make([]float64, 0, len(amounts))
+	for _, text := range amounts {
+		amount, err := ParseInvoiceAmount(text)
+		if err != nil {
+			return errors.New(text + ": " + err.Error())
+		}
+		presets = append(presets, amount)
+	}
+	if err := app.UpdateCompany(ctx, company.Id, func(saved *Company) {
+		saved.InvoicePresets = presets
+	}); err != nil {
+		return err
+	}
+	company.InvoicePresets = presets
+	return nil
+}
+
+func (app *App) InvoicePresets(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	if args := (*tlg).Args(); len(args) > 0 {
+		if len(args) == 1 && args[0] == "-" {
+			args = nil
+		}
+		if err := app.SetPresets(ctx, company, args); err != nil {
+			return (*tlg).Send(err.Error(), menu)
+		}
+	}
+	presets := make([]string, 0, len(company.Presets()))
+	for _, amount := range company.Presets() {
+		presets = append(presets, FormatRubles(amount))
+	}
+	return (*tlg).Send("Суммы счетов: "+strings.Join(presets, ", ")+
+		"\n\nИзменить: /presets 5000 15000 50000\nВернуть стандартные: /presets -", menu)
+}
+
+// invoiceAmountPattern is the amount without spaces and with a dot, other forms ParseFloat accepts
+// like "1e5", "0x1p10", "Inf" or "NaN" are not amounts.
+var invoiceAmountPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// ParseInvoiceAmount accepts amounts like "15000", "15 000" or "15000,50".
+func ParseInvoiceAmount(text string) (float64, error) {
+	text = strings.NewReplacer(" ", "", " ", "", "₽", "", ",", ".").Replace(text)
+	if !invoiceAmountPattern.MatchString(text) {
+		return 0, errors.New("не могу разобрать сумму, пришли только число, например 15000")
+	}
+	amount, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, errors.New("не могу разобрать сумму, пришли только число, например 15000")
+	}
+	if dot := strings.Index(text, "."); dot >= 0 && len(text)-dot-1 > 2 {
+		return 0, errors.New("в сумме может быть не больше двух знаков после запятой")
+	}
+	if amount < minInvoiceAmount || amount > maxInvoiceAmount {
+		return 0, errors.New("сумма счёта должна быть от " + strconv.Itoa(minInvoiceAmount) + " до " + strconv.Itoa(maxInvoiceAmount) + " ₽")
+	}
+	return amount, nil
+}
+
+func (app *App) AskInvoiceAmount(tlg *tele.Context) error {
+	if _, err := app.StartDialog(*tlg, "invoice", "amount"); err != nil {
+		return (*tlg).Send(err.Error())
+	}
+	return (*tlg).Send("На какую сумму нужен счёт?\nОт "+strconv.Itoa(minInvoiceAmount)+" до "+strconv.Itoa(maxInvoiceAmount)+" ₽", app.cancelMenu)
+}
+
+func (app *App) ReceiveInvoiceAmount(tlg tele.Context, state *DialogState) error {
+	ctx := UpdateContext(tlg)
+	amount, err := ParseInvoiceAmount(tlg.Text())
+	if err != nil {
+		return tlg.Send(err.Error()+"\nПопробуй ещё раз или нажми «Отмена»", app.cancelMenu)
+	}
+	state.SetFloat("amount", amount)
+	if err := app.NextStep(ctx, state, "confirm"); err != nil {
+		return tlg.Send(err.Error(), app.cancelMenu)
+	}
+	return tlg.Send("Создать счёт на "+FormatRubles(amount)+"?", app.confirmInvoiceMenu)
+}
+
+func (app *App) ConfirmInvoice(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	_, _, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	// Only the press which has consumed the confirmation creates the invoice
+	state, err := app.ConsumeDialogState(ctx, (*tlg).Chat().ID, "invoice", "confirm")
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if state == nil {
+		return (*tlg).Send("Этот счёт уже создан или отменён", menu)
+	}
+	return app.Invoice(tlg, state.Float("amount"))
+}
+
+// InvoiceRecord is an invoice created through the bot.
+type InvoiceRecord struct {
+	Id        string
+	CompanyId int
+	Number    string
+	Amount    float64
+	CreatedAt time.Time
+	CreatorId int64
+	FileName  string
+	FileId    string
+	Paid      bool
+	PaidAt    time.Time
+	// RemindedAt is the time of the last reminder about the unpaid invoice
+	RemindedAt time.Time
+}
+
+func NewInvoiceRecord(company *Company, user *User, amount float64, fileName string) *InvoiceRecord {
+	now := time.Now()
+	return &InvoiceRecord{
+		Id:        strconv.Itoa(company.Id) + "-" + strconv.FormatInt(now.UnixNano(), 10),
+		CompanyId: company.Id,
+		Number:    strings.TrimSuffix(fileName, path.Ext(fileName)),
+		Amount:    amount,
+		CreatedAt: now,
+		CreatorId: user.Id,
+		FileName:  fileName,
+	}
+}
+
+func (app *App) SaveInvoiceRecord(ctx context.Context, record *InvoiceRecord) error {
+	recordLogger := app.log.WithField("companyId", record.CompanyId).WithField("invoiceId", record.Id)
+	recordLogger.Trace("Saving invoice...")
+	if err := app.saveGob(ctx, app.collection("invoices").Doc(record.Id), record, map[string]interface{}{"companyId": record.CompanyId, "paid": record.Paid}); err != nil {
+		recordLogger.Warn(err)
+		return err
+	}
+	recordLogger.Info("Saved!")
+	return nil
+}
+
+func (app *App) LoadInvoiceRecord(ctx context.Context, id string) (record *InvoiceRecord, err error) {
+	recordLogger := app.log.WithField("invoiceId", id)
+	recordLogger.Trace("Loading invoice...")
+	reqCtx, cancel := app.storageContext(ctx)
+	recordDoc, err := app.collection("invoices").Doc(id).Get(reqCtx)
+	cancel()
+	if err != nil {
+		recordLogger.Warn(err)
+		return nil, err
+	}
+	record = &InvoiceRecord{}
+	if err := decodeGob(recordDoc, record); err != nil {
+		recordLogger.Warn(err)
+		return nil, err
+	}
+	recordLogger.Info("Loaded!")
+	return record, nil
+}
+
+// LoadInvoiceRecords returns invoices of the company, the newest first.
+func (app *App) LoadInvoiceRecords(ctx context.Context, companyId int) (records []*InvoiceRecord, err error) {
+	companyLogger := app.log.WithField("companyId", companyId)
+	companyLogger.Trace("Loading invoices...")
+	reqCtx, cancel := app.storageContext(ctx)
+	recordDocs, err := app.collection("invoices").Where("companyId", "==", companyId).Documents(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		companyLogger.Warn(err)
+		return nil, err
+	}
+	for _, recordDoc := range recordDocs {
+		record := &InvoiceRecord{}
+		if err := decodeGob(recordDoc, record); err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.After(records[j].CreatedAt)
+	})
+	companyLogger.Info("Loaded invoices: ", len(records))
+	return records, nil
+}
+
+// MatchPayment marks the unpaid invoice closest to the balance increase as paid.
+func (app *App) MatchPayment(ctx context.Context, companyId int, increase float64) (*InvoiceRecord, error) {
+	records, err := app.LoadInvoiceRecords(ctx, companyId)
+	if err != nil {
+		return nil, err
+	}
+	var paid *InvoiceRecord
+	for _, record := range records {
+		if record.Paid || math.Abs(record.Amount-increase) > record.Amount*paymentTolerance {
+			continue
+		}
+		// Records are the newest first, so an equally close older invoice wins
+		if paid == nil || math.Abs(record.Amount-increase) <= math.Abs(paid.Amount-increase) {
+			paid = record
+		}
+	}
+	if paid == nil {
+		return nil, nil
+	}
+	paid.Paid = true
+	paid.PaidAt = time.Now()
+	return paid, app.SaveInvoiceRecord(ctx, paid)
+}
+
+func (app *App) Invoices(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	records, err := app.LoadInvoiceRecords(ctx, company.Id)
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if len(records) == 0 {
+		return (*tlg).Send("Через бота ещё не создано ни одного счёта", menu)
+	}
+	if len(records) > 20 {
+		records = records[:20]
+	}
+	mes := "Счета, созданные через бота:\n"
+	for _, record := range records {
+		status := "⏳ не оплачен"
+		if record.Paid {
+			status = "✅ оплачен " + record.PaidAt.Format("02.01.2006")
+		}
+		mes += "\n" + record.String() + "\n" + status + "\n"
+	}
+	return (*tlg).Send(mes, menu)
+}
+
+func (record *InvoiceRecord) String() string {
+	return "№ " + record.Number + " от " + record.CreatedAt.Format("02.01.2006") + " на " + FormatRubles(record.Amount)
+}
+
+func (app *App) UnpaidInvoiceMenu(record *InvoiceRecord) *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data("Прислать счёт", app.btnResendInvoice.Unique, record.Id),
+		menu.Data("Оплачен", app.btnMarkInvoicePaid.Unique, record.Id),
+	))
+	return menu
+}
+
+// NotifyAboutUnpaidInvoices reminds company admins about invoices unpaid for reminder_days, once a day.
+func (app *App) NotifyAboutUnpaidInvoices(ctx context.Context, b Sender) {
+	app.log.Trace("Reminding about unpaid invoices...")
+	reqCtx, cancel := app.storageContext(ctx)
+	recordDocs, err := app.collection("invoices").Where("paid", "==", false).Documents(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		app.log.Warn(err)
+		return
+	}
+
+	admins := make(map[int][]*User)
+	for _, recordDoc := range recordDocs {
+		record := &InvoiceRecord{}
+		if err := decodeGob(recordDoc, record); err != nil {
+			app.log.Warn(err)
+			continue
+		}
+		if time.Since(record.CreatedAt) < time.Duration(app.config.ReminderDays)*24*time.Hour || time.Since(record.RemindedAt) < 24*time.Hour {
+			continue
+		}
+
+		recordLogger := app.log.WithField("companyId", record.CompanyId).WithField("invoiceId", record.Id)
+		if _, ok := admins[record.CompanyId]; !ok {
+			users, err := app.LoadCompanyUsers(ctx, record.CompanyId)
+			if err != nil {
+				recordLogger.Warn(err)
+				continue
+			}
+			for _, user := range users {
+				if user.Admin {
+					admins[record.CompanyId] = append(admins[record.CompanyId], user)
+				}
+			}
+		}
+
+		days := int(time.Since(record.CreatedAt).Hours() / 24)
+		mes := "⏰ Счёт " + record.String() + " не оплачен уже " + strconv.Itoa(days) + " дн."
+		for _, admin := range admins[record.CompanyId] {
+			if _, err := b.Send(admin, mes, app.UnpaidInvoiceMenu(record)); err != nil {
+				recordLogger.Warn(err)
+			}
+		}
+		record.RemindedAt = time.Now()
+		app.SaveInvoiceRecord(ctx, record)
+	}
+	app.log.Trace("Reminded about unpaid invoices")
+}
+
+// companyInvoiceRecord loads the invoice of the button, making sure it belongs to the company of the user.
+func (app *App) companyInvoiceRecord(tlg *tele.Context) (*InvoiceRecord, *tele.ReplyMarkup, error) {
+	ctx := UpdateContext(*tlg)
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return nil, app.startMenu, err
+	}
+	record, err := app.LoadInvoiceRecord(ctx, (*tlg).Data())
+	if err != nil || record.CompanyId != company.Id {
+		return nil, menu, errors.New("не могу найти этот счёт")
+	}
+	return record, menu, nil
+}
+
+func (app *App) ResendInvoice(tlg *tele.Context) error {
+	record, menu, err := app.companyInvoiceRecord(tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if record.FileId == "" {
+		return (*tlg).Send("Не сохранил файл этого счёта, его можно найти в личном кабинете Делимобиль", menu)
+	}
+	doc := &tele.Document{File: tele.File{FileID: record.FileId}, FileName: record.FileName}
+	return (*tlg).Send(doc, menu)
+}
+
+func (app *App) MarkInvoicePaid(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	record, menu, err := app.companyInvoiceRecord(tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if !record.Paid {
+		record.Paid = true
+		record.PaidAt = time.Now()
+		if err := app.SaveInvoiceRecord(ctx, record); err != nil {
+			return (*tlg).Send(err.Error(), menu)
+		}
+	}
+	return (*tlg).Send("✅ Счёт "+record.String()+" оплачен", menu)
+}
@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseInvoiceAmount(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want float64
+		ok   bool
+	}{
+		{"15000", 15000, true},
+		{"15 000", 15000, true},
+		{"15 000 ₽", 15000, true},
+		{"15000,50", 15000.5, true},
+		{"15000.5", 15000.5, true},
+		{"1000", 1000, true},
+		{"1000000", 1000000, true},
+		{"999,99", 0, false},
+		{"1000000,01", 0, false},
+		{"15000,505", 0, false},
+		{"NaN", 0, false},
+		{"nan", 0, false},
+		{"Inf", 0, false},
+		{"+Inf", 0, false},
+		{"1e5", 0, false},
+		{"0x1p14", 0, false},
+		{"-5000", 0, false},
+		{"+5000", 0, false},
+		{"5_000", 0, false},
+		{"15000,", 0, false},
+		{",5", 0, false},
+		{"", 0, false},
+		{"пятнадцать тысяч", 0, false},
+	} {
+		amount, err := ParseInvoiceAmount(test.text)
+		if (err == nil) != test.ok || amount != test.want {
+			t.Errorf("ParseInvoiceAmount(%q) = %v, %v, want %v, ok %v", test.text, amount, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestFormatRubles(t *testing.T) {
+	for amount, want := range map[float64]string{
+		0:          "0 ₽",
+		5:          "5 ₽",
+		999:        "999 ₽",
+		1000:       "1 000 ₽",
+		12345.5:    "12 345,50 ₽",
+		1000000:    "1 000 000 ₽",
+		0.07:       "0,07 ₽",
+		999.994:    "999,99 ₽",
+		999.999:    "1 000 ₽",
+		123456.789: "123 456,79 ₽",
+		-1500.5:    "-1 500,50 ₽",
+		-0.001:     "0 ₽",
+	} {
+		if got := FormatRubles(amount); got != want {
+			t.Errorf("FormatRubles(%v) = %q, want %q", amount, got, want)
+		}
+	}
+}
+
+func TestMatchPayment(t *testing.T) {
+	h := newTestHarness(t)
+	ctx := context.Background()
+	now := time.Now()
+	for _, record := range []*InvoiceRecord{
+		{Id: "older", Amount: 10000, CreatedAt: now.Add(-2 * time.Hour)},
+		{Id: "newer", Amount: 10000, CreatedAt: now.Add(-time.Hour)},
+		{Id: "paid", Amount: 3000, CreatedAt: now.Add(-time.Hour), Paid: true},
+		{Id: "small", Amount: 5000, CreatedAt: now.Add(-3 * time.Hour)},
+	} {
+		record.CompanyId = 1
+		if err := h.App.SaveInvoiceRecord(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		increase float64
+		want     string
+	}{
+		{11001, ""},
+		{8999, ""},
+		// The paid invoice isn't paid again and 5000 is too far
+		{3000, ""},
+		// Equally close invoices are paid from the oldest
+		{9500, "older"},
+		{10400, "newer"},
+		{9000, ""},
+		{4550, "small"},
+	} {
+		record, err := h.App.MatchPayment(ctx, 1, test.increase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if record != nil {
+			got = record.Id
+		}
+		if got != test.want {
+			t.Errorf("MatchPayment(%v) = %q, want %q", test.increase, got, test.want)
+		}
+	}
+
+	records, err := h.App.LoadInvoiceRecords(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("%d invoices are loaded, want 4", len(records))
+	}
+	for _, record := range records {
+		if !record.Paid {
+			t.Errorf("invoice %s isn't saved as paid", record.Id)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// Share of the monthly limit at which the employee gets a warning.
+const limitWarnShare = 0.8
+
+// LimitAlert remembers which alerts about the employee's limit were already sent in the month.
+type LimitAlert struct {
+	Month    string
+	Warned   bool
+	Exceeded bool
+}
+
+func (app *App) SetCompanyLimit(ctx context.Context, company *Company, phone string, amount float64) error {
+	phone = NormalizePhone(phone)
+	if phone == "" {
+		return errors.New("не могу разобрать номер телефона")
+	}
+	if amount < 0 {
+		return errors.New("лимит не может быть отрицательным")
+	}
+	var limits map[string]float64
+	if err := app.UpdateCompany(ctx, company.Id, func(saved *Company) {
+		if saved.Limits == nil {
+			saved.Limits = make(map[string]float64)
+		}
+		if amount == 0 {
+			delete(saved.Limits, phone)
+		} else {
+			saved.Limits[phone] = amount
+		}
+		limits = saved.Limits
+	}); err != nil {
+		return err
+	}
+	company.Limits = limits
+	return nil
+}
+
+func LimitsMessage(company *Company, report []*EmployeeSpending) string {
+	if len(company.Limits) == 0 {
+		return "Лимиты не установлены.\nУстановить: /limit телефон сумма\nСнять: /limit телефон 0"
+	}
+	spent := make(map[string]*EmployeeSpending)
+	for _, spending := range report {
+		spent[NormalizePhone(spending.Phone)] = spending
+	}
+	phones := make([]string, 0, len(company.Limits))
+	for phone := range company.Limits {
+		phones = append(phones, phone)
+	}
+	sort.Strings(phones)
+
+	mes := "Месячные лимиты сотрудников:\n"
+	for _, phone := range phones {
+		var (
+			name = "+" + phone
+			cost float64
+		)
+		if spending, ok := spent[phone]; ok {
+			name = spending.Name + " (+" + phone + ")"
+			cost = spending.Cost
+		}
+		mes += "\n" + name + "\n" +
+			"Потрачено " + strconv.FormatFloat(cost, 'f', 2, 64) + " из " + strconv.FormatFloat(company.Limits[phone], 'f', 2, 64) + " ₽\n"
+	}
+	return mes
+}
+
+func (app *App) Limits(tlg *tele.Context) error {
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	report, err := app.EmployeesSpending(company, RelativeMonthPeriod("current"))
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	return (*tlg).Send(LimitsMessage(company, report), menu)
+}
+
+func (app *App) SetLimit(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	args := (*tlg).Args()
+	if len(args) != 2 {
+		return (*tlg).Send("Что-то не так.\nПравильное использование:\n /limit телефон сумма", menu)
+	}
+	amount, err := strconv.ParseFloat(strings.Replace(args[1], ",", ".", 1), 64)
+	if err != nil {
+		return (*tlg).Send("Не могу разобрать сумму лимита", menu)
+	}
+	if err := app.SetCompanyLimit(ctx, company, args[0], amount); err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if amount == 0 {
+		return (*tlg).Send("✅ Лимит снят", menu)
+	}
+	return (*tlg).Send("✅ Лимит установлен", menu)
+}
+
+func (app *App) NotifyAboutSpendingLimits(ctx context.Context, b Sender) {
+	app.log.Trace("Checking spending limits...")
+	reqCtx, cancel := app.storageContext(ctx)
+	companyDocRefs, err := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("companies").DocumentRefs(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		app.log.Warn(err)
+		return
+	}
+
+	for _, companyDocRef := range companyDocRefs {
+		companyId, err := strconv.Atoi(companyDocRef.ID)
+		if err != nil {
+			app.log.Warn(err)
+			continue
+		}
+
+		companyLogger := app.log.WithField("companyId", companyId)
+		company, err := app.readCompany(ctx, companyId)
+		if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		if len(company.Limits) == 0 {
+			continue
+		}
+		if err := app.AuthenticateCompany(ctx, company); errors.Is(err, ErrReauthRequired) {
+			continue
+		} else if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+
+		period := RelativeMonthPeriod("current")
+		report, err := app.EmployeesSpending(company, period)
+		if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		users, err := app.LoadCompanyUsers(ctx, company.Id)
+		if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		if app.CheckSpendingLimits(company, b, report, users, period) {
+			app.UpdateCompany(ctx, company.Id, func(saved *Company) {
+				saved.LimitAlerts = company.LimitAlerts
+			})
+		}
+	}
+	app.log.Trace("Checked spending limits")
+}
+
+// CheckSpendingLimits warns employees approaching their limit and admins about exceeded ones.
+// Returns true if any alert was sent and company.LimitAlerts should be saved.
+func (app *App) CheckSpendingLimits(company *Company, b Sender, report []*EmployeeSpending, users []*User, period Period) (changed bool) {
+	companyLogger := app.log.WithField("companyId", company.Id)
+	if company.LimitAlerts == nil {
+		company.LimitAlerts = make(map[string]LimitAlert)
+	}
+	month := period.From.Format("2006-01")
+
+	for _, spending := range report {
+		phone := NormalizePhone(spending.Phone)
+		limit, ok := company.Limits[phone]
+		if !ok {
+			continue
+		}
+		alert := company.LimitAlerts[phone]
+		if alert.Month != month {
+			alert = LimitAlert{Month: month}
+		}
+
+		usage := "Потрачено " + strconv.FormatFloat(spending.Cost, 'f', 2, 64) + " из " + strconv.FormatFloat(limit, 'f', 2, 64) + " ₽ за " + period.String()
+		if spending.Cost >= limitWarnShare*limit && !alert.Warned {
+			for _, user := range users {
+				if NormalizePhone(user.Phone) != phone {
+					continue
+				}
+				if _, err := b.Send(user, "⚠️ Ты израсходовал больше "+strconv.Itoa(int(limitWarnShare*100))+"% месячного лимита\n"+usage); err != nil {
+					companyLogger.Warn(err)
+				}
+			}
+			alert.Warned = true
+		}
+		if spending.Cost > limit && !alert.Exceeded {
+			for _, user := range users {
+				if !user.Admin {
+					continue
+				}
+				if _, err := b.Send(user, "🚨 "+spending.Name+" (+"+phone+") превысил месячный лимит\n"+usage); err != nil {
+					companyLogger.Warn(err)
+				}
+			}
+			alert.Exceeded = true
+		}
+
+		// Alerts of the past month are reset only when a new one is sent, not to save the company needlessly
+		if (alert.Warned || alert.Exceeded) && alert != company.LimitAlerts[phone] {
+			company.LimitAlerts[phone] = alert
+			changed = true
+		}
+	}
+	return changed
+}
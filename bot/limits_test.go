@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	deli "github.com/fuksman/delimobil"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// recordingSender records messages by recipient instead of sending them.
+type recordingSender struct {
+	sent map[string][]string
+}
+
+func (sender *recordingSender) Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	if sender.sent == nil {
+		sender.sent = make(map[string][]string)
+	}
+	text, _ := what.(string)
+	sender.sent[to.Recipient()] = append(sender.sent[to.Recipient()], text)
+	return &tele.Message{}, nil
+}
+
+func TestCheckSpendingLimits(t *testing.T) {
+	app := newOfflineApp(t, newFakeAcme())
+	company := &Company{Company: &deli.Company{Id: 1}, Limits: map[string]float64{"79000000001": 1000, "79000000002": 1000}}
+	users := []*User{
+		{Id: 1, Phone: "+79000000001"},
+		{Id: 2, Phone: "+79000000002"},
+		{Id: 3, Phone: "+79000000003", Admin: true},
+	}
+	october := MonthPeriod(time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC))
+
+	for _, test := range []struct {
+		name    string
+		period  Period
+		report  []*EmployeeSpending
+		changed bool
+		// Number of messages by recipient
+		sent map[string]int
+	}{
+		{
+			"below the warning",
+			october,
+			[]*EmployeeSpending{{Name: "Первый", Phone: "79000000001", Cost: 799}, {Name: "Без лимита", Phone: "79000000003", Cost: 5000}},
+			false,
+			map[string]int{},
+		},
+		{
+			"warning",
+			october,
+			[]*EmployeeSpending{{Name: "Первый", Phone: "79000000001", Cost: 800}},
+			true,
+			map[string]int{"1": 1},
+		},
+		{
+			"warning is sent once",
+			october,
+			[]*EmployeeSpending{{Name: "Первый", Phone: "79000000001", Cost: 900}},
+			false,
+			map[string]int{},
+		},
+		{
+			"exceeded at once",
+			october,
+			[]*EmployeeSpending{{Name: "Второй", Phone: "79000000002", Cost: 1000.01}},
+			true,
+			map[string]int{"2": 1, "3": 1},
+		},
+		{
+			"exceeded after the warning",
+			october,
+			[]*EmployeeSpending{{Name: "Первый", Phone: "79000000001", Cost: 1200}, {Name: "Второй", Phone: "79000000002", Cost: 1500}},
+			true,
+			map[string]int{"3": 1},
+		},
+		{
+			"new month",
+			MonthPeriod(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)),
+			[]*EmployeeSpending{{Name: "Первый", Phone: "79000000001", Cost: 850}},
+			true,
+			map[string]int{"1": 1},
+		},
+	} {
+		sender := &recordingSender{}
+		changed := app.CheckSpendingLimits(company, sender, test.report, users, test.period)
+		if changed != test.changed {
+			t.Errorf("%s: changed = %v, want %v", test.name, changed, test.changed)
+		}
+		for _, user := range users {
+			if got := len(sender.sent[user.Recipient()]); got != test.sent[user.Recipient()] {
+				t.Errorf("%s: %d messages to user %d, want %d: %q", test.name, got, user.Id, test.sent[user.Recipient()], sender.sent[user.Recipient()])
+			}
+		}
+	}
+
+	sender := &recordingSender{}
+	company.LimitAlerts = nil
+	app.CheckSpendingLimits(company, sender, []*EmployeeSpending{{Name: "Второй", Phone: "79000000002", Cost: 1500}}, users, october)
+	if mes := sender.sent["3"]; len(mes) != 1 || !strings.HasPrefix(mes[0], "🚨 Второй (+79000000002) превысил месячный лимит\nПотрачено 1500.00 из 1000.00 ₽ за 10.2021") {
+		t.Errorf("admin alert = %q", mes)
+	}
+}
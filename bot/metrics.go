@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CounterVec is a Prometheus counter with a single label.
+type CounterVec struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// SummaryVec is a Prometheus summary without quantiles: only sum and count, with a single label.
+type SummaryVec struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	sums   map[string]float64
+	counts map[string]uint64
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	return &CounterVec{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+func NewSummaryVec(name, help, label string) *SummaryVec {
+	return &SummaryVec{name: name, help: help, label: label, sums: make(map[string]float64), counts: make(map[string]uint64)}
+}
+
+func (c *CounterVec) Inc(label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[label]++
+}
+
+func (s *SummaryVec) Observe(label string, val float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sums[label] += val
+	s.counts[label]++
+}
+
+func (s *SummaryVec) ObserveSince(label string, start time.Time) {
+	s.Observe(label, time.Since(start).Seconds())
+}
+
+func escapeLabel(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(val)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *CounterVec) Write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %g\n", c.name, c.label, escapeLabel(key), c.values[key])
+	}
+}
+
+func (s *SummaryVec) Write(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", s.name, s.help, s.name)
+	for _, key := range sortedKeys(s.sums) {
+		fmt.Fprintf(w, "%s_sum{%s=\"%s\"} %g\n", s.name, s.label, escapeLabel(key), s.sums[key])
+		fmt.Fprintf(w, "%s_count{%s=\"%s\"} %d\n", s.name, s.label, escapeLabel(key), s.counts[key])
+	}
+}
+
+// Metrics are the counters of a single app, so apps running in one process don't mix them up.
+type Metrics struct {
+	handlerCalls     *CounterVec
+	handlerErrors    *CounterVec
+	delimobilLatency *SummaryVec
+	delimobilErrors  *CounterVec
+	notifierTicks    *SummaryVec
+	messagesSent     *CounterVec
+	outboxPostponed  *CounterVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		handlerCalls:     NewCounterVec("dlmbltlg_handler_calls_total", "Telegram updates handled.", "endpoint"),
+		handlerErrors:    NewCounterVec("dlmbltlg_handler_errors_total", "Telegram updates handled with error.", "endpoint"),
+		delimobilLatency: NewSummaryVec("dlmbltlg_delimobil_request_duration_seconds", "Duration of Delimobil API calls.", "method"),
+		delimobilErrors:  NewCounterVec("dlmbltlg_delimobil_errors_total", "Failed Delimobil API calls.", "method"),
+		notifierTicks:    NewSummaryVec("dlmbltlg_notifier_tick_duration_seconds", "Duration of notifier ticks.", "notifier"),
+		messagesSent:     NewCounterVec("dlmbltlg_telegram_messages_sent_total", "Successful Telegram send requests.", "method"),
+		outboxPostponed:  NewCounterVec("dlmbltlg_outbox_failures_total", "Queued messages failed to send, postponed or dropped.", "reason"),
+	}
+}
+
+func (metrics *Metrics) Write(w io.Writer) {
+	metrics.handlerCalls.Write(w)
+	metrics.handlerErrors.Write(w)
+	metrics.delimobilLatency.Write(w)
+	metrics.delimobilErrors.Write(w)
+	metrics.notifierTicks.Write(w)
+	metrics.messagesSent.Write(w)
+	metrics.outboxPostponed.Write(w)
+}
+
+// observeDelimobil is deferred in Delimobil calls: defer metrics.observeDelimobil("SetInfo", time.Now(), &err).
+func (metrics *Metrics) observeDelimobil(method string, start time.Time, err *error) {
+	metrics.delimobilLatency.ObserveSince(method, start)
+	if err != nil && *err != nil {
+		metrics.delimobilErrors.Inc(method)
+	}
+}
+
+// metricsTransport counts successful Telegram requests sending anything to chats.
+type metricsTransport struct {
+	next http.RoundTripper
+	sent *CounterVec
+}
+
+func (t *metricsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(r)
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if err == nil && resp.StatusCode == http.StatusOK && strings.HasPrefix(method, "send") {
+		t.sent.Inc(method)
+	}
+	return resp, err
+}
@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+type MonitoringConfig struct {
+	Listen string `json:"listen"`
+	// DelimobilURL of the API host is requested to check Delimobil is reachable
+	DelimobilURL string `json:"delimobil_url"`
+}
+
+// commandEndpoints are the commands counted separately, other texts starting with "/" are counted as "other"
+// to keep the number of labels bounded.
+var commandEndpoints = map[string]bool{
+	"/start": true, "/auth": true, "/stop": true, "/cancel": true, "/purpose": true,
+	"/presets": true, "/closing": true, "/reconcile": true, "/export": true, "/report": true,
+	"/limits": true, "/limit": true, "/emails": true, "/emaillog": true, "/costcenters": true,
+}
+
+// Notifier is a named check run on every notifier tick.
+type Notifier struct {
+	Name   string
+	Notify func(context.Context, Sender)
+}
+
+// How long a single notifier may run, including all its storage requests
+const notifierTimeout = 10 * time.Minute
+
+// NotifierTick runs the notifiers one after another observing their durations.
+// While Delimobil is unavailable for every company notifiers are skipped instead of failing for each of them.
+func (app *App) NotifierTick(b Sender, notifiers []Notifier) {
+	atomic.StoreInt64(&app.notifierTickStarted, time.Now().Unix())
+	for _, notifier := range notifiers {
+		if app.breakers.AllOpen() {
+			app.log.Trace("Delimobil is unavailable, skipping notifiers.")
+			break
+		}
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(app.ctx, notifierTimeout)
+		notifier.Notify(ctx, b)
+		cancel()
+		app.metrics.notifierTicks.ObserveSince(notifier.Name, start)
+	}
+	atomic.StoreInt64(&app.notifierTickFinished, time.Now().Unix())
+}
+
+// NotifierAlive reports if the notifier is ticking: the last tick is not older than a few check delays
+// and the current one does not hang.
+func (app *App) NotifierAlive() bool {
+	allowed := 3 * time.Duration(app.config.CheckDelay) * time.Second
+	started := time.Unix(atomic.LoadInt64(&app.notifierTickStarted), 0)
+	finished := time.Unix(atomic.LoadInt64(&app.notifierTickFinished), 0)
+	if started.Before(app.startedAt) {
+		return time.Since(app.startedAt) < allowed
+	}
+	if finished.Before(started) {
+		// The tick is in progress
+		return time.Since(started) < allowed+time.Duration(app.config.CheckDelay)*time.Second
+	}
+	return time.Since(finished) < allowed
+}
+
+// metricsMiddleware counts handled updates by endpoint.
+func (app *App) metricsMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(tlg tele.Context) error {
+		endpoint := "text"
+		switch {
+		case tlg.Callback() != nil:
+			endpoint = "callback:" + tlg.Callback().Unique
+		case tlg.Message() != nil && tlg.Message().Contact != nil:
+			endpoint = "contact"
+		case strings.HasPrefix(tlg.Text(), "/"):
+			// Commands may be addressed to the bot as /start@bot_name
+			endpoint = "other"
+			if command := strings.Split(strings.Fields(tlg.Text())[0], "@")[0]; commandEndpoints[command] {
+				endpoint = command
+			}
+		}
+		app.metrics.handlerCalls.Inc(endpoint)
+		err := next(tlg)
+		if err != nil {
+			app.metrics.handlerErrors.Inc(endpoint)
+		}
+		return err
+	}
+}
+
+// StartMonitoring serves /healthz, /readyz and /metrics if monitoring is configured.
+func (app *App) StartMonitoring(config *MonitoringConfig) {
+	if config == nil {
+		return
+	}
+	delimobilURL := config.DelimobilURL
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !app.NotifierAlive() {
+			http.Error(w, "notifier is not ticking", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		checkCtx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		if _, err := app.collection("companies").Limit(1).Documents(checkCtx).GetAll(); err != nil {
+			http.Error(w, "storage: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		// The breakers are open after the API calls the bot makes have failed for every company
+		if app.breakers.AllOpen() {
+			http.Error(w, "delimobil: API calls are failing", http.StatusServiceUnavailable)
+			return
+		}
+		if delimobilURL == "" {
+			w.Write([]byte("ok\n"))
+			return
+		}
+		req, err := http.NewRequestWithContext(checkCtx, http.MethodHead, delimobilURL, nil)
+		if err != nil {
+			http.Error(w, "delimobil: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			http.Error(w, "delimobil: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			http.Error(w, "delimobil: "+resp.Status, http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		app.metrics.Write(w)
+	})
+
+	app.monitoring = &http.Server{Addr: config.Listen, Handler: mux}
+	go func() {
+		app.log.Trace("Starting monitoring server...")
+		if err := app.monitoring.ListenAndServe(); err != http.ErrServerClosed {
+			app.log.Error("Monitoring server stopped: ", err)
+		}
+	}()
+}
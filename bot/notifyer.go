@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// Sender sends messages on the bot's own initiative, *tele.Bot is the one sending them right away.
+type Sender interface {
+	Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error)
+}
+
+func (app *App) NotifyAboutBalanceChange(ctx context.Context, b Sender) {
+	app.log.Trace("Notifying users about changes...")
+	companyLastBalance := make(map[int]*Company)
+
+	reqCtx, cancel := app.storageContext(ctx)
+	companyDocRefs, err := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("companies").DocumentRefs(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		app.log.Warn(err)
+	}
+
+	for _, companyDocRef := range companyDocRefs {
+		companyId, err := strconv.Atoi(companyDocRef.ID)
+		if err != nil {
+			app.log.Warn(err)
+		}
+
+		companyLogger := app.log.WithField("companyId", companyId)
+		company, err := app.LoadCompany(ctx, companyId)
+		if errors.Is(err, ErrReauthRequired) {
+			continue
+		}
+		if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+
+		if err := company.SetInfo(); err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		// Companies saved before BalanceKnown was added have a non-zero balance known
+		known := company.BalanceKnown || company.KnownBalance != 0
+		if !known || company.Balance != company.KnownBalance {
+			if known && company.Balance > company.KnownBalance {
+				if record, err := app.MatchPayment(ctx, company.Id, company.Balance-company.KnownBalance); err != nil {
+					companyLogger.Warn(err)
+				} else if record != nil {
+					companyLogger.Info("Invoice " + record.Number + " is paid")
+				}
+			}
+			balance := company.Balance
+			app.UpdateCompany(ctx, company.Id, func(saved *Company) {
+				saved.KnownBalance = balance
+				saved.BalanceKnown = true
+			})
+		}
+		companyLastBalance[company.Id] = company
+		companyLogger.Trace("Saved updated company to the map")
+	}
+	app.log.Trace("Checked all companies")
+
+	reqCtx, cancel = app.storageContext(ctx)
+	userDocRefs, err := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("users").DocumentRefs(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		app.log.Warn(err)
+	}
+	for _, userDocRef := range userDocRefs {
+		userId, err := strconv.ParseInt(userDocRef.ID, 10, 64)
+		if err != nil {
+			app.log.Warn(err)
+		}
+
+		userLogger := app.log.WithField("userId", userId)
+		user, err := app.LoadUser(ctx, userId)
+		if err != nil {
+			userLogger.Warn(err)
+			continue
+		}
+		if user.Inactive {
+			continue
+		}
+
+		if company, ok := companyLastBalance[user.CompanyId]; ok {
+			companyLogger := userLogger.WithField("companyId", company.Id)
+			companyLogger.Trace("Found user's company in balances list")
+
+			if app.Permissions(company, user) == "" {
+				companyLogger.Warn("User doesn't have permissions for this company")
+				break
+			}
+
+			balance := company.Balance
+			if balance != user.LastBalance {
+				companyLogger.Trace("User's last balance defers")
+				mes := "💸 Баланс компании " + company.Info.Name + " изменился\n" +
+					"Текущий баланс: " + strconv.FormatFloat(company.Info.Balance, 'f', 2, 64) + " ₽"
+				if _, err := b.Send(user, mes); err == nil {
+					companyLogger.Trace("Notifyed user! Updating user's last balance...")
+					user.LastBalance = balance
+					if err := app.SaveUser(ctx, user); err == nil {
+						companyLogger.Trace("Updated user's last balance!")
+					} else {
+						companyLogger.Warn(err)
+					}
+				} else {
+					companyLogger.Warn(err)
+				}
+			}
+			companyLogger.Trace("User's last balance is actual, not notifying")
+		}
+	}
+	app.log.Trace("Checked all users")
+}
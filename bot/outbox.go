@@ -0,0 +1,241 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+const (
+	// Telegram allows about 30 messages per second overall and about one per second to the same chat
+	outboxGlobalInterval = time.Second / 30
+	outboxChatInterval   = time.Second
+	// How often the stored messages are checked if nothing new was queued
+	outboxPollInterval = 5 * time.Second
+	outboxBatchSize    = 100
+	// Failed messages are retried with a growing pause, the messages this old are not relevant anymore
+	outboxMaxBackoff = 10 * time.Minute
+	outboxMaxAge     = 24 * time.Hour
+)
+
+// OutboundMessage is the notification waiting to be sent.
+type OutboundMessage struct {
+	ChatId    int64
+	Text      string
+	Markup    *tele.ReplyMarkup
+	ParseMode tele.ParseMode
+	Created   time.Time
+	NotBefore time.Time
+	Attempts  int
+}
+
+// Outbox is the Sender queueing notifications in the storage and sending them within Telegram limits.
+// A message is removed only when sent, so it survives 429 responses, network failures and restarts.
+type Outbox struct {
+	app *App
+	bot Sender
+
+	globalInterval time.Duration
+	chatInterval   time.Duration
+
+	mu       sync.Mutex
+	lastSent time.Time
+	// The time each chat may receive the next message
+	chatNext map[int64]time.Time
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+func NewOutbox(app *App, bot Sender) *Outbox {
+	return &Outbox{
+		app:            app,
+		bot:            bot,
+		globalInterval: outboxGlobalInterval,
+		chatInterval:   outboxChatInterval,
+		chatNext:       make(map[int64]time.Time),
+		wake:           make(chan struct{}, 1),
+		done:           make(chan struct{}),
+	}
+}
+
+// Send queues the text message, it's sent later by Run. The message returned is always nil.
+// Only a reply markup and a parse mode are supported as options.
+func (outbox *Outbox) Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	text, ok := what.(string)
+	if !ok {
+		return nil, errors.New("only text messages can be queued")
+	}
+	chatId, err := strconv.ParseInt(to.Recipient(), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	message := &OutboundMessage{ChatId: chatId, Text: text, Created: time.Now(), NotBefore: time.Now()}
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case *tele.ReplyMarkup:
+			message.Markup = opt
+		case tele.ParseMode:
+			message.ParseMode = opt
+		default:
+			return nil, errors.New("unsupported option of the queued message")
+		}
+	}
+
+	if err := outbox.save(outbox.app.collection("outbox").NewDoc(), message); err != nil {
+		outbox.app.log.WithField("userId", chatId).Warn(err)
+		return nil, err
+	}
+	select {
+	case outbox.wake <- struct{}{}:
+	default:
+	}
+	return nil, nil
+}
+
+func (outbox *Outbox) save(docRef *firestore.DocumentRef, message *OutboundMessage) error {
+	return outbox.app.saveGob(outbox.app.ctx, docRef, message, map[string]interface{}{"notBefore": message.NotBefore})
+}
+
+// Run sends the queued messages until ctx is done.
+func (outbox *Outbox) Run(ctx context.Context) {
+	defer close(outbox.done)
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		outbox.Flush(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-outbox.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush sends the messages which are due now as fast as the limits allow.
+// Messages of the chat which can't receive them yet are left for the next time, keeping their order.
+func (outbox *Outbox) Flush(ctx context.Context) {
+	reqCtx, cancel := outbox.app.storageContext(ctx)
+	docs, err := outbox.app.collection("outbox").Where("notBefore", "<=", time.Now()).OrderBy("notBefore", firestore.Asc).Limit(outboxBatchSize).Documents(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		outbox.app.log.Warn(err)
+		return
+	}
+
+	postponed := make(map[int64]bool)
+	for _, doc := range docs {
+		message := &OutboundMessage{}
+		if err := decodeGob(doc, message); err != nil {
+			outbox.app.log.Warn(err)
+			continue
+		}
+		if postponed[message.ChatId] || !outbox.chatReady(message.ChatId) {
+			postponed[message.ChatId] = true
+			continue
+		}
+		if !outbox.waitGlobal(ctx) {
+			return
+		}
+		if !outbox.deliver(doc.Ref, message) {
+			postponed[message.ChatId] = true
+		}
+	}
+}
+
+func (outbox *Outbox) chatReady(chatId int64) bool {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	return !time.Now().Before(outbox.chatNext[chatId])
+}
+
+// waitGlobal waits for the global limit to allow the next message, false means ctx is done.
+func (outbox *Outbox) waitGlobal(ctx context.Context) bool {
+	outbox.mu.Lock()
+	wait := time.Until(outbox.lastSent.Add(outbox.globalInterval))
+	outbox.mu.Unlock()
+	if wait > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// deliver sends the message and removes it from the queue or reschedules it, false means it's postponed.
+func (outbox *Outbox) deliver(docRef *firestore.DocumentRef, message *OutboundMessage) bool {
+	messageLogger := outbox.app.log.WithField("userId", message.ChatId)
+	var opts []interface{}
+	if message.Markup != nil {
+		opts = append(opts, message.Markup)
+	}
+	if message.ParseMode != "" {
+		opts = append(opts, message.ParseMode)
+	}
+
+	now := time.Now()
+	_, err := outbox.bot.Send(tele.ChatID(message.ChatId), message.Text, opts...)
+	outbox.mu.Lock()
+	outbox.lastSent = now
+	outbox.chatNext[message.ChatId] = now.Add(outbox.chatInterval)
+	outbox.mu.Unlock()
+
+	var flood tele.FloodError
+	switch {
+	case err == nil:
+		outbox.remove(docRef)
+		return true
+	case errors.As(err, &flood):
+		messageLogger.Warn(err)
+		outbox.app.metrics.outboxPostponed.Inc("flood")
+		retryAfter := time.Duration(flood.RetryAfter) * time.Second
+		outbox.mu.Lock()
+		outbox.chatNext[message.ChatId] = now.Add(retryAfter)
+		outbox.mu.Unlock()
+		message.NotBefore = now.Add(retryAfter)
+	case outbox.app.DeactivateIfUnreachable(outbox.app.ctx, message.ChatId, err):
+		outbox.app.metrics.outboxPostponed.Inc("unreachable")
+		outbox.remove(docRef)
+		return true
+	case rejectedByTelegram(err) || time.Since(message.Created) > outboxMaxAge:
+		messageLogger.Warn("Dropping the message: ", err)
+		outbox.app.metrics.outboxPostponed.Inc("dropped")
+		outbox.remove(docRef)
+		return true
+	default:
+		messageLogger.Warn(err)
+		outbox.app.metrics.outboxPostponed.Inc("error")
+		message.Attempts++
+		backoff := time.Second << message.Attempts
+		if backoff > outboxMaxBackoff || backoff <= 0 {
+			backoff = outboxMaxBackoff
+		}
+		message.NotBefore = now.Add(backoff)
+	}
+	if err := outbox.save(docRef, message); err != nil {
+		messageLogger.Warn(err)
+	}
+	return false
+}
+
+// rejectedByTelegram reports if Telegram has refused the message itself, so repeating it is useless.
+func rejectedByTelegram(err error) bool {
+	var apiErr *tele.APIError
+	return errors.As(err, &apiErr) && apiErr.Code >= 400 && apiErr.Code < 500 && apiErr.Code != 429
+}
+
+func (outbox *Outbox) remove(docRef *firestore.DocumentRef) {
+	reqCtx, cancel := outbox.app.storageContext(outbox.app.ctx)
+	defer cancel()
+	if _, err := docRef.Delete(reqCtx); err != nil {
+		outbox.app.log.Warn(err)
+	}
+}
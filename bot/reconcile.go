@@ -0,0 +1,251 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	deli "github.com/fuksman/delimobil"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// RentEntry is a single rent line of the rentsDetail closing document.
+type RentEntry struct {
+	RideId    int
+	StartTime time.Time
+	Cost      float64
+}
+
+type Reconciliation struct {
+	Period     Period
+	Missing    deli.Rides
+	Extra      []RentEntry
+	Mismatched []CostMismatch
+}
+
+type CostMismatch struct {
+	Ride  deli.Ride
+	Entry RentEntry
+}
+
+// Costs differing less than a kopeck are equal.
+const costTolerance = 0.01
+
+var rentDateLayouts = []string{"02.01.2006 15:04:05", "02.01.2006 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05", "02.01.2006"}
+
+// ParseRentsDetail reads rent entries from the rentsDetail document in XLSX or CSV format.
+// Columns are found by the header row: ride number, start date and cost.
+func ParseRentsDetail(file *deli.File) ([]RentEntry, error) {
+	data, err := io.ReadAll(file.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	switch {
+	case strings.HasSuffix(strings.ToLower(file.FileName), ".xlsx"):
+		rows, err = ReadXLSX(data)
+	case strings.HasSuffix(strings.ToLower(file.FileName), ".csv"):
+		r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\uFEFF"))))
+		r.Comma = ';'
+		r.FieldsPerRecord = -1
+		rows, err = r.ReadAll()
+	default:
+		return nil, errors.New("не умею читать детализацию в формате " + file.MIME)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	idCol, dateCol, costCol, header := -1, -1, -1, -1
+	for i, row := range rows {
+		startCol := false
+		for col, title := range row {
+			title = strings.ToLower(title)
+			switch {
+			case isRideIdTitle(title):
+				if idCol < 0 {
+					idCol = col
+				}
+			case strings.Contains(title, "начал") || strings.Contains(title, "дата"):
+				// The start of the ride is preferred to other dates, e.g. of the end
+				if dateCol < 0 || !startCol && strings.Contains(title, "начал") {
+					dateCol, startCol = col, strings.Contains(title, "начал")
+				}
+			case strings.Contains(title, "стоимость") || strings.Contains(title, "сумма"):
+				if costCol < 0 {
+					costCol = col
+				}
+			}
+		}
+		if dateCol >= 0 && costCol >= 0 {
+			header = i
+			break
+		}
+		idCol, dateCol, costCol = -1, -1, -1
+	}
+	if header < 0 {
+		return nil, errors.New("не нашёл в детализации столбцы с датой и стоимостью поездки")
+	}
+
+	var entries []RentEntry
+	for _, row := range rows[header+1:] {
+		if dateCol >= len(row) || costCol >= len(row) {
+			continue
+		}
+		start, err := parseRentDate(row[dateCol])
+		if err != nil {
+			continue
+		}
+		cost, err := parseRentCost(row[costCol])
+		if err != nil {
+			continue
+		}
+		entry := RentEntry{StartTime: start, Cost: cost}
+		if idCol >= 0 && idCol < len(row) {
+			entry.RideId, _ = strconv.Atoi(strings.TrimSpace(row[idCol]))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// isRideIdTitle reports if the column title is of the ride number, "Госномер" or "Номер автомобиля" of the car is not.
+func isRideIdTitle(title string) bool {
+	if strings.Contains(title, "номер") {
+		return !strings.Contains(title, "гос") && !strings.Contains(title, "автомоб")
+	}
+	for _, word := range strings.FieldsFunc(title, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if word == "id" {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRentDate(val string) (time.Time, error) {
+	val = strings.TrimSpace(val)
+	// XLSX keeps dates as days since 1899-12-30
+	if serial, err := strconv.ParseFloat(val, 64); err == nil {
+		epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.Local)
+		return epoch.Add(time.Duration(serial * 24 * float64(time.Hour))).Round(time.Second), nil
+	}
+	for _, layout := range rentDateLayouts {
+		if t, err := time.ParseInLocation(layout, val, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("не могу разобрать дату " + val)
+}
+
+func parseRentCost(val string) (float64, error) {
+	val = strings.NewReplacer(" ", "", "\u00a0", "", "₽", "", ",", ".").Replace(val)
+	return strconv.ParseFloat(val, 64)
+}
+
+// Reconcile matches rides with rent entries by ride number or, without it, by start minute.
+func Reconcile(rides deli.Rides, entries []RentEntry, period Period) *Reconciliation {
+	result := &Reconciliation{Period: period}
+	matched := make([]bool, len(entries))
+	for _, ride := range rides {
+		found := false
+		for i, entry := range entries {
+			if matched[i] {
+				continue
+			}
+			if entry.RideId != 0 && entry.RideId != ride.Id {
+				continue
+			}
+			if entry.RideId == 0 && !entry.StartTime.Truncate(time.Minute).Equal(ride.StartTime.Truncate(time.Minute)) {
+				continue
+			}
+			matched[i], found = true, true
+			if math.Abs(entry.Cost-ride.Cost) >= costTolerance {
+				result.Mismatched = append(result.Mismatched, CostMismatch{Ride: ride, Entry: entry})
+			}
+			break
+		}
+		if !found {
+			result.Missing = append(result.Missing, ride)
+		}
+	}
+	for i, entry := range entries {
+		if !matched[i] {
+			result.Extra = append(result.Extra, entry)
+		}
+	}
+	return result
+}
+
+func (result *Reconciliation) String() string {
+	if len(result.Missing) == 0 && len(result.Extra) == 0 && len(result.Mismatched) == 0 {
+		return "✅ Детализация за " + result.Period.String() + " совпадает с поездками"
+	}
+	mes := "⚠️ Расхождения детализации за " + result.Period.String() + ":\n"
+	if len(result.Missing) > 0 {
+		mes += "\nНет в детализации:\n"
+		for _, ride := range result.Missing {
+			mes += ride.StartTime.Format("02.01.2006 15:04") + " " + ride.Employee + " — " + strconv.FormatFloat(ride.Cost, 'f', 2, 64) + " ₽\n"
+		}
+	}
+	if len(result.Extra) > 0 {
+		mes += "\nЕсть только в детализации:\n"
+		for _, entry := range result.Extra {
+			mes += entry.StartTime.Format("02.01.2006 15:04") + " — " + strconv.FormatFloat(entry.Cost, 'f', 2, 64) + " ₽\n"
+		}
+	}
+	if len(result.Mismatched) > 0 {
+		mes += "\nОтличается стоимость:\n"
+		for _, mismatch := range result.Mismatched {
+			mes += mismatch.Ride.StartTime.Format("02.01.2006 15:04") + " " + mismatch.Ride.Employee + ": " +
+				strconv.FormatFloat(mismatch.Ride.Cost, 'f', 2, 64) + " ₽ в поездках, " +
+				strconv.FormatFloat(mismatch.Entry.Cost, 'f', 2, 64) + " ₽ в детализации\n"
+		}
+	}
+	return mes
+}
+
+// ReconcileRentsDetail compares the last rentsDetail document with the rides of its month.
+func (app *App) ReconcileRentsDetail(tlg *tele.Context) error {
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+
+	userLogger := app.log.WithField("userId", user.Id)
+	userLogger.Trace("Retrieving last rentsDetail...")
+	rentsDetail, err := company.LastFileByType("rentsDetail")
+	if err != nil {
+		userLogger.Warn("Can't retrieve last rentsDetail.")
+		return (*tlg).Send(err.Error(), menu)
+	}
+	entries, err := ParseRentsDetail(rentsDetail)
+	if err != nil {
+		userLogger.Warn(err)
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if len(entries) == 0 {
+		return (*tlg).Send("В детализации нет поездок", menu)
+	}
+
+	first := entries[0].StartTime
+	for _, entry := range entries {
+		if entry.StartTime.Before(first) {
+			first = entry.StartTime
+		}
+	}
+	period := MonthPeriod(first)
+	rides, err := app.RidesForPeriod(company, period)
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+
+	userLogger.Info("Reconciled rentsDetail.")
+	return (*tlg).Send(truncateMessage(Reconcile(rides, entries, period).String()), menu)
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	deli "github.com/fuksman/delimobil"
+)
+
+// rentsDetailCSV is the detail the way Delimobil sends it: a title above the header and the car plate before the ride number.
+const rentsDetailCSV = "\uFEFFДетализация поездок за 10.2021;;;;\n" +
+	";;;;\n" +
+	"Госномер;Номер поездки;Дата окончания;Дата начала;Стоимость, ₽\n" +
+	"А123ВС777;101;01.10.2021 09:30:00;01.10.2021 09:05:00;180,00\n" +
+	"В456ОР777;102;02.10.2021 19:10;02.10.2021 18:30;1 450,50\n" +
+	"Итого;;;;1 630,50\n"
+
+func TestParseRentsDetail(t *testing.T) {
+	entries, err := ParseRentsDetail(&deli.File{FileName: "rentsDetail.csv", Data: strings.NewReader(rentsDetailCSV)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RentEntry{
+		{RideId: 101, StartTime: time.Date(2021, 10, 1, 9, 5, 0, 0, time.Local), Cost: 180},
+		{RideId: 102, StartTime: time.Date(2021, 10, 2, 18, 30, 0, 0, time.Local), Cost: 1450.5},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("entries = %+v, want %+v", entries, want)
+	}
+
+	table := &Table{Name: "rentsDetail", Header: []string{"Ride ID", "Start", "Paid", "Дата", "Сумма"}}
+	table.AddRow(7, "x", "no", "01.10.2021 09:05", 99.9)
+	xlsx, err := table.XLSX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Buffer{}
+	data.ReadFrom(xlsx.Data)
+	entries, err = ParseRentsDetail(&deli.File{FileName: "rentsDetail.XLSX", Data: &data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []RentEntry{{RideId: 7, StartTime: time.Date(2021, 10, 1, 9, 5, 0, 0, time.Local), Cost: 99.9}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("xlsx entries = %+v, want %+v", entries, want)
+	}
+
+	if _, err := ParseRentsDetail(&deli.File{FileName: "rentsDetail.csv", Data: strings.NewReader("Госномер;Поездка\nА123ВС777;1\n")}); err == nil {
+		t.Error("detail without date and cost is parsed")
+	}
+	if _, err := ParseRentsDetail(&deli.File{FileName: "rentsDetail.pdf", MIME: "application/pdf", Data: strings.NewReader("%PDF")}); err == nil {
+		t.Error("pdf is parsed")
+	}
+}
+
+func TestIsRideIdTitle(t *testing.T) {
+	for title, want := range map[string]bool{
+		"номер поездки":    true,
+		"номер":            true,
+		"ride id":          true,
+		"id":               true,
+		"госномер":         false,
+		"гос. номер":       false,
+		"номер автомобиля": false,
+		"paid":             false,
+		"дата начала":      false,
+	} {
+		if got := isRideIdTitle(title); got != want {
+			t.Errorf("isRideIdTitle(%q) = %v, want %v", title, got, want)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2021, 10, day, hour, minute, second, 0, time.Local)
+	}
+	rides := deli.Rides{
+		{Id: 1, StartTime: at(1, 9, 5, 10), Employee: "Иванов", Cost: 180},
+		{Id: 2, StartTime: at(2, 18, 30, 0), Employee: "Петров", Cost: 450.5},
+		{Id: 3, StartTime: at(3, 8, 0, 0), Employee: "Сидоров", Cost: 300},
+		{Id: 4, StartTime: at(4, 12, 0, 0), Employee: "Иванов", Cost: 100},
+	}
+	entries := []RentEntry{
+		// By number, within a kopeck
+		{RideId: 1, StartTime: at(1, 9, 5, 0), Cost: 180.004},
+		// By the start minute without number, the cost differs
+		{StartTime: at(2, 18, 30, 59), Cost: 455.5},
+		// The number of another ride is not matched by time
+		{RideId: 9, StartTime: at(4, 12, 0, 0), Cost: 100},
+	}
+	result := Reconcile(rides, entries, MonthPeriod(at(1, 0, 0, 0)))
+	if len(result.Missing) != 2 || result.Missing[0].Id != 3 || result.Missing[1].Id != 4 {
+		t.Errorf("missing = %+v", result.Missing)
+	}
+	if len(result.Extra) != 1 || result.Extra[0].RideId != 9 {
+		t.Errorf("extra = %+v", result.Extra)
+	}
+	if len(result.Mismatched) != 1 || result.Mismatched[0].Ride.Id != 2 || result.Mismatched[0].Entry.Cost != 455.5 {
+		t.Errorf("mismatched = %+v", result.Mismatched)
+	}
+
+	result = Reconcile(rides[:1], entries[:1], MonthPeriod(at(1, 0, 0, 0)))
+	if mes := result.String(); mes != "✅ Детализация за 10.2021 совпадает с поездками" {
+		t.Errorf("message = %q", mes)
+	}
+}
@@ -0,0 +1,403 @@
+package bot
+
+import (
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// registerHandlers declares everything the bot responds to.
+func (app *App) registerHandlers() {
+	b := app.bot
+	b.Use(app.withUpdateContext, app.metricsMiddleware)
+
+	b.Handle("/start", func(tlg tele.Context) error {
+		ctx := UpdateContext(tlg)
+		app.Reactivate(ctx, tlg.Sender().ID)
+		return tlg.Send("Для работы мне нужен твой телефонный номер.", app.startMenu)
+	})
+
+	b.Handle(tele.OnContact, func(tlg tele.Context) error {
+		ctx := UpdateContext(tlg)
+		if tlg.Message().Contact.UserID != int(tlg.Sender().ID) {
+			return tlg.Send("😡 Меня не обманешь, можно прислать только свой контакт", app.startMenu)
+		}
+
+		user := &User{Id: tlg.Sender().ID, Phone: tlg.Message().Contact.PhoneNumber}
+
+		if err := app.SaveUser(ctx, user); err != nil {
+			return tlg.Send("Не могу сохранить информацию 😔", app.startMenu)
+		}
+
+		company, err := app.FindCompany(ctx, user)
+		if err != nil {
+			return tlg.Send("Произошла ошибка при поиске подключенных компаний 😔", app.startMenu)
+		}
+
+		if company != nil {
+			company.SetInfo()
+			return tlg.Send("👋 Привет!\nТеперь у тебя есть доступ к компании "+company.Info.Name, app.emplMenu)
+		}
+
+		return tlg.Send("Сохранил, но не могу найти ни одну подходящую компанию.\nАдминистратор должен подключить компанию к боту или добавить тебя в список сотрудников.", app.unAuthMenu)
+	})
+
+	b.Handle("Я администратор", func(tlg tele.Context) error {
+		return tlg.Send("Отправь команду\n`/auth login password`\n где `login` и `password` — реквизиты доступа к личному кабинету Делимобиль", tele.ModeMarkdownV2)
+	})
+
+	// Multi-step dialogs
+	app.RegisterDialog(&Dialog{
+		Name:       "invoice",
+		Middleware: []tele.MiddlewareFunc{app.provideUserToContext, app.provideCompanyToContext, app.ensureIsAdmin},
+		Steps: map[string]StepFunc{
+			"amount": app.ReceiveInvoiceAmount,
+		},
+	})
+
+	app.RegisterDialog(&Dialog{
+		Name:       "topUp",
+		Middleware: []tele.MiddlewareFunc{app.provideUserToContext, app.provideCompanyToContext},
+		Steps: map[string]StepFunc{
+			"amount": app.ReceiveTopUpAmount,
+			"reason": app.ReceiveTopUpReason,
+		},
+	})
+
+	b.Handle(tele.OnText, app.HandleDialogReply)
+	b.Handle("/cancel", app.CancelDialog)
+	b.Handle("Отмена", app.CancelDialog)
+
+	// User-related handlers
+	userBot := b.Group()
+	userBot.Use(app.provideUserToContext)
+
+	userBot.Handle("/auth", func(tlg tele.Context) error {
+		ctx := UpdateContext(tlg)
+		removed := "\nДля безопасности я удалил сообщение с логином и паролем."
+		if len(tlg.Args()) != 2 {
+			tlg.Delete()
+			return tlg.Send("Что-то не так.\nПравильное использование:\n /auth login password" + removed)
+		}
+
+		userLogger := app.log.WithField("userId", tlg.Sender().ID)
+		userLogger.Trace("Updating user token...")
+
+		company := app.NewCompany(ctx, tlg.Args()[0], tlg.Args()[1])
+		if err := company.Authenticate(); err != nil {
+			userLogger.Warn(err)
+			return tlg.Send(err.Error())
+		}
+		userLogger.Info("Token updated.")
+
+		app.RestoreSettings(ctx, company)
+		if err := app.SaveCompany(ctx, company); err != nil {
+			tlg.Delete()
+			return tlg.Send(err.Error() + removed)
+		}
+
+		user, ok := tlg.Get("user").(*User)
+		if !ok {
+			return tlg.Send("Не могу получить информацию о пользователе" + removed)
+		}
+		user.CompanyId = company.Id
+		user.Admin = true
+		app.SetLastBalance(user, company)
+		if err := app.SaveUser(ctx, user); err != nil {
+			tlg.Delete()
+			return tlg.Send("Авторизация прошла, но с ошибкой:\n"+err.Error()+removed, app.startMenu)
+		}
+
+		if err := company.SetInfo(); err != nil {
+			tlg.Delete()
+			return tlg.Send("Авторизация прошла, но с ошибкой:\n"+err.Error()+removed, app.startMenu)
+		}
+
+		tlg.Delete()
+		return tlg.Send("Предоставлен доступ к компании "+company.Info.Name+"!\nВсё настроено, можем работать."+removed, app.adminMenu)
+	})
+
+	userBot.Handle("Разлогиниться", app.SignOut())
+	userBot.Handle("/stop", app.SignOut())
+
+	// Company-related handlers
+	companyBot := b.Group()
+	companyBot.Use(app.provideUserToContext)
+	companyBot.Use(app.provideCompanyToContext)
+
+	companyBot.Handle("Баланс", func(tlg tele.Context) error {
+		ctx := UpdateContext(tlg)
+		user, company, menu, err := ReadContext(tlg)
+		if err != nil {
+			return tlg.Send(err.Error(), app.startMenu)
+		}
+
+		if err := company.SetInfo(); err != nil {
+			return tlg.Send(err.Error(), menu)
+		}
+
+		mes := company.Info.Name + "\n" +
+			"Текущий баланс: " + strconv.FormatFloat(company.Info.Balance, 'f', 2, 64) + " ₽"
+
+		err = tlg.Send(mes, menu)
+		if err == nil {
+			app.SetLastBalance(user, company)
+			app.SaveUser(ctx, user)
+		}
+
+		return err
+	})
+
+	companyBot.Handle("Поездки", func(tlg tele.Context) error {
+		_, company, menu, err := ReadContext(tlg)
+		if err != nil {
+			return tlg.Send(err.Error(), app.startMenu)
+		}
+
+		if err := company.SetInfo(); err != nil {
+			return tlg.Send(err.Error(), menu)
+		}
+		if err := company.SetRides(10, 1); err != nil {
+			return tlg.Send(err.Error(), menu)
+		}
+		mes := "Последние поездки:\n" + company.Rides.String()
+
+		return tlg.Send(mes, menu)
+	})
+
+	companyBot.Handle("Запросить пополнение", func(tlg tele.Context) error {
+		return app.AskTopUpAmount(&tlg)
+	})
+
+	companyBot.Handle("/purpose", func(tlg tele.Context) error {
+		if len(tlg.Args()) < 2 {
+			return tlg.Send("Что-то не так.\nПравильное использование:\n /purpose номер_поездки цель")
+		}
+		rideId, err := strconv.Atoi(tlg.Args()[0])
+		if err != nil {
+			return tlg.Send("Не могу разобрать номер поездки")
+		}
+		return app.TagRide(&tlg, rideId, strings.Join(tlg.Args()[1:], " "))
+	})
+
+	companyBot.Handle(&app.btnRidePurpose, func(tlg tele.Context) error {
+		_, company, _, err := ReadContext(tlg)
+		if err != nil {
+			return tlg.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		rideId, purpose, err := PurposeFromButton(company, tlg.Args())
+		if err != nil {
+			return tlg.Respond(&tele.CallbackResponse{Text: err.Error()})
+		}
+		app.TagRide(&tlg, rideId, purpose)
+		return tlg.Respond()
+	})
+
+	// Admin-only handlers
+	adminBot := b.Group()
+	adminBot.Use(app.provideUserToContext)
+	adminBot.Use(app.provideCompanyToContext)
+	adminBot.Use(app.ensureIsAdmin)
+
+	adminBot.Handle("Последний счёт", func(tlg tele.Context) error {
+		return app.Invoice(&tlg)
+	})
+
+	adminBot.Handle("Новый счёт", func(tlg tele.Context) error {
+		return app.SendInvoiceMenu(&tlg)
+	})
+
+	adminBot.Handle("Счета", func(tlg tele.Context) error {
+		return app.Invoices(&tlg)
+	})
+
+	adminBot.Handle(&app.btnResendInvoice, func(tlg tele.Context) error {
+		app.ResendInvoice(&tlg)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle(&app.btnMarkInvoicePaid, func(tlg tele.Context) error {
+		app.MarkInvoicePaid(&tlg)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle(&app.btnApproveTopUp, func(tlg tele.Context) error {
+		app.ApproveTopUp(&tlg)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle(&app.btnRejectTopUp, func(tlg tele.Context) error {
+		app.RejectTopUp(&tlg)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle("Последние закрывающие", func(tlg tele.Context) error {
+		return app.LastClosingDocuments(&tlg)
+	})
+
+	adminBot.Handle(&app.btnNewInvoicePreset, func(tlg tele.Context) error {
+		amount, err := strconv.ParseFloat(tlg.Data(), 64)
+		if err != nil {
+			return tlg.Respond(&tele.CallbackResponse{Text: "Не могу разобрать сумму счёта"})
+		}
+		app.Invoice(&tlg, amount)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle("/presets", func(tlg tele.Context) error {
+		return app.InvoicePresets(&tlg)
+	})
+
+	adminBot.Handle(&app.btnLastInvoice, func(tlg tele.Context) error {
+		app.Invoice(&tlg)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle(&app.btnNewInvoiceCustom, func(tlg tele.Context) error {
+		app.AskInvoiceAmount(&tlg)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle(&app.btnConfirmInvoice, func(tlg tele.Context) error {
+		app.ConfirmInvoice(&tlg)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle(&app.btnCancelInvoice, func(tlg tele.Context) error {
+		app.CancelDialog(tlg)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle("Закрывающие ZIP", func(tlg tele.Context) error {
+		return app.ClosingDocumentsZip(&tlg, RelativeMonthPeriod("previous"))
+	})
+
+	adminBot.Handle("/closing", func(tlg tele.Context) error {
+		if len(tlg.Args()) != 1 {
+			return tlg.Send("Что-то не так.\nПравильное использование:\n /closing ГГГГ-ММ")
+		}
+		period, err := ParseMonthPeriod(tlg.Args()[0])
+		if err != nil {
+			return tlg.Send(err.Error())
+		}
+		return app.ClosingDocumentsZip(&tlg, period)
+	})
+
+	adminBot.Handle("Документы", func(tlg tele.Context) error {
+		return app.SendDocumentTypes(&tlg)
+	})
+
+	adminBot.Handle(&app.btnDocumentTypes, func(tlg tele.Context) error {
+		app.SendDocumentTypes(&tlg)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle(&app.btnDocumentType, func(tlg tele.Context) error {
+		app.SendDocumentMonths(&tlg, tlg.Data())
+		return tlg.Respond()
+	})
+
+	adminBot.Handle(&app.btnDocumentMonth, func(tlg tele.Context) error {
+		if len(tlg.Args()) != 2 {
+			return tlg.Respond()
+		}
+		app.SendDocumentList(&tlg, tlg.Args()[0], tlg.Args()[1])
+		return tlg.Respond()
+	})
+
+	adminBot.Handle(&app.btnDocumentFile, func(tlg tele.Context) error {
+		id, err := strconv.Atoi(tlg.Data())
+		if err != nil {
+			return tlg.Respond(&tele.CallbackResponse{Text: "Не могу найти документ"})
+		}
+		app.SendArchiveDocument(&tlg, id)
+		return tlg.Respond()
+	})
+
+	adminBot.Handle("Сверка детализации", func(tlg tele.Context) error {
+		return app.ReconcileRentsDetail(&tlg)
+	})
+
+	adminBot.Handle("/reconcile", func(tlg tele.Context) error {
+		return app.ReconcileRentsDetail(&tlg)
+	})
+
+	adminBot.Handle("Выгрузка поездок", func(tlg tele.Context) error {
+		return app.SendExportMenu(&tlg)
+	})
+
+	adminBot.Handle("/export", func(tlg tele.Context) error {
+		if len(tlg.Args()) != 2 {
+			return tlg.Send("Что-то не так.\nПравильное использование:\n /export csv|xlsx ГГГГ-ММ")
+		}
+		period, err := ParseMonthPeriod(tlg.Args()[1])
+		if err != nil {
+			return tlg.Send(err.Error())
+		}
+		return app.ExportRides(&tlg, tlg.Args()[0], period)
+	})
+
+	adminBot.Handle(&app.btnExportRides, func(tlg tele.Context) error {
+		if len(tlg.Args()) != 2 {
+			return tlg.Respond()
+		}
+		app.ExportRides(&tlg, tlg.Args()[0], RelativeMonthPeriod(tlg.Args()[1]))
+		return tlg.Respond()
+	})
+
+	adminBot.Handle("Отчёт по сотрудникам", func(tlg tele.Context) error {
+		return app.SendReportMenu(&tlg)
+	})
+
+	adminBot.Handle("/report", func(tlg tele.Context) error {
+		if len(tlg.Args()) < 1 || len(tlg.Args()) > 2 {
+			return tlg.Send("Что-то не так.\nПравильное использование:\n /report ГГГГ-ММ [csv|xlsx]")
+		}
+		period, err := ParseMonthPeriod(tlg.Args()[0])
+		if err != nil {
+			return tlg.Send(err.Error())
+		}
+		format := ""
+		if len(tlg.Args()) == 2 {
+			format = tlg.Args()[1]
+		}
+		return app.SpendingReport(&tlg, period, format)
+	})
+
+	adminBot.Handle(&app.btnSpendingReport, func(tlg tele.Context) error {
+		if len(tlg.Args()) != 2 {
+			return tlg.Respond()
+		}
+		app.SpendingReport(&tlg, RelativeMonthPeriod(tlg.Args()[0]), tlg.Args()[1])
+		return tlg.Respond()
+	})
+
+	adminBot.Handle("Лимиты", func(tlg tele.Context) error {
+		return app.Limits(&tlg)
+	})
+
+	adminBot.Handle("/limits", func(tlg tele.Context) error {
+		return app.Limits(&tlg)
+	})
+
+	adminBot.Handle("/limit", func(tlg tele.Context) error {
+		return app.SetLimit(&tlg)
+	})
+
+	adminBot.Handle("/emails", func(tlg tele.Context) error {
+		return app.Emails(&tlg)
+	})
+
+	adminBot.Handle("/emaillog", func(tlg tele.Context) error {
+		return app.EmailLog(&tlg)
+	})
+
+	adminBot.Handle("Цели поездок", func(tlg tele.Context) error {
+		return app.CostCenters(&tlg)
+	})
+
+	adminBot.Handle("/costcenters", func(tlg tele.Context) error {
+		return app.CostCenters(&tlg)
+	})
+}
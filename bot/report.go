@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	deli "github.com/fuksman/delimobil"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+type EmployeeSpending struct {
+	Name    string
+	Phone   string
+	Rides   int
+	Minutes int
+	Cost    float64
+}
+
+// NormalizePhone keeps only digits, so "+7 (900) 000-00-00" and "79000000000" are the same phone.
+func NormalizePhone(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// EmployeesSpending aggregates the rides of the period by employee, the most spending first.
+func (app *App) EmployeesSpending(company *Company, period Period) ([]*EmployeeSpending, error) {
+	rides, err := app.RidesForPeriod(company, period)
+	if err != nil {
+		return nil, err
+	}
+	if err := company.SetEmployees(); err != nil {
+		app.log.WithField("companyId", company.Id).Warn(err)
+		return nil, err
+	}
+	return AggregateSpending(rides, company.Employees), nil
+}
+
+func AggregateSpending(rides deli.Rides, employees deli.Employees) []*EmployeeSpending {
+	names := make(map[string]string)
+	for _, employee := range employees {
+		names[NormalizePhone(employee.Phone)] = employee.Name
+	}
+
+	byPhone := make(map[string]*EmployeeSpending)
+	for _, ride := range rides {
+		phone := NormalizePhone(ride.Phone)
+		spending, ok := byPhone[phone]
+		if !ok {
+			spending = &EmployeeSpending{Name: ride.Employee, Phone: ride.Phone}
+			if name, ok := names[phone]; ok {
+				spending.Name = name
+			}
+			byPhone[phone] = spending
+		}
+		spending.Rides++
+		spending.Minutes += ride.Duration
+		spending.Cost += ride.Cost
+	}
+
+	report := make([]*EmployeeSpending, 0, len(byPhone))
+	for _, spending := range byPhone {
+		report = append(report, spending)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Cost > report[j].Cost
+	})
+	return report
+}
+
+// Telegram doesn't accept messages longer than 4096 characters, some are left for the ending
+const maxMessageLength = 4000
+
+// truncateMessage cuts the message to the length Telegram accepts.
+func truncateMessage(mes string) string {
+	if runes := []rune(mes); len(runes) > maxMessageLength {
+		return string(runes[:maxMessageLength]) + "\n…"
+	}
+	return mes
+}
+
+// SpendingMessage lists the employees while the message fits Telegram, the total always counts all of them.
+func SpendingMessage(report []*EmployeeSpending, period Period) string {
+	var (
+		total   float64
+		mes     = "Расходы сотрудников за " + period.String() + ":\n"
+		omitted int
+	)
+	for _, spending := range report {
+		total += spending.Cost
+		entry := "\n" + spending.Name + " (" + spending.Phone + ")\n" +
+			"Поездок: " + strconv.Itoa(spending.Rides) + ", минут: " + strconv.Itoa(spending.Minutes) + "\n" +
+			"Сумма: " + strconv.FormatFloat(spending.Cost, 'f', 2, 64) + " ₽\n"
+		if omitted > 0 || utf8.RuneCountInString(mes+entry) > maxMessageLength-200 {
+			omitted++
+			continue
+		}
+		mes += entry
+	}
+	if omitted > 0 {
+		mes += "\n…и ещё сотрудников: " + strconv.Itoa(omitted) + ", все они есть в отчёте XLSX\n"
+	}
+	return mes + "\nИтого: " + strconv.FormatFloat(total, 'f', 2, 64) + " ₽"
+}
+
+func SpendingTable(report []*EmployeeSpending, name string) *Table {
+	table := &Table{
+		Name:   name,
+		Header: []string{"Сотрудник", "Телефон", "Поездок", "Минут", "Сумма, ₽"},
+	}
+	for _, spending := range report {
+		table.AddRow(spending.Name, spending.Phone, spending.Rides, spending.Minutes, spending.Cost)
+	}
+	return table
+}
+
+// SpendingReport sends the per-employee report for the period, with format "csv" or "xlsx" also attaching it as a spreadsheet.
+func (app *App) SpendingReport(tlg *tele.Context, period Period, format string) error {
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+
+	userLogger := app.log.WithField("userId", user.Id)
+	userLogger.Trace("Building spending report...")
+	report, err := app.EmployeesSpending(company, period)
+	if err != nil {
+		userLogger.Warn("Can't build spending report.")
+		return (*tlg).Send(err.Error(), menu)
+	}
+	if len(report) == 0 {
+		return (*tlg).Send("Нет поездок за "+period.String(), menu)
+	}
+	if err := (*tlg).Send(SpendingMessage(report, period), menu); err != nil || format == "" {
+		return err
+	}
+
+	var (
+		attachment *deli.File
+		table      = SpendingTable(report, "spending-"+period.From.Format("2006-01"))
+	)
+	switch format {
+	case "csv":
+		attachment, err = table.CSV()
+	case "xlsx":
+		attachment, err = table.XLSX()
+	default:
+		return (*tlg).Send("Формат отчёта должен быть csv или xlsx", menu)
+	}
+	if err != nil {
+		userLogger.Warn(err)
+		return (*tlg).Send(err.Error(), menu)
+	}
+	userLogger.Info("Built spending report.")
+	doc := &tele.Document{File: tele.FromReader(attachment.Data)}
+	doc.FileName = attachment.FileName
+	doc.MIME = attachment.MIME
+	return (*tlg).Send(doc, menu)
+}
@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	deli "github.com/fuksman/delimobil"
+)
+
+func TestAggregateSpending(t *testing.T) {
+	rides := deli.Rides{
+		{Id: 1, Employee: "Иванов", Phone: "+7 (900) 000-00-01", Duration: 10, Cost: 100},
+		{Id: 2, Employee: "Петров", Phone: "79000000002", Duration: 30, Cost: 500.5},
+		{Id: 3, Employee: "Иванов И.", Phone: "79000000001", Duration: 20, Cost: 200},
+		{Id: 4, Employee: "Без имени", Phone: "79000000003", Duration: 5, Cost: 50},
+	}
+	employees := deli.Employees{
+		{Name: "Иван Иванов", Phone: "+79000000001"},
+		{Name: "Пётр Петров", Phone: "+79000000002"},
+	}
+	want := []*EmployeeSpending{
+		{Name: "Пётр Петров", Phone: "79000000002", Rides: 1, Minutes: 30, Cost: 500.5},
+		{Name: "Иван Иванов", Phone: "+7 (900) 000-00-01", Rides: 2, Minutes: 30, Cost: 300},
+		// The name of the former employee is taken from the ride
+		{Name: "Без имени", Phone: "79000000003", Rides: 1, Minutes: 5, Cost: 50},
+	}
+	if report := AggregateSpending(rides, employees); !reflect.DeepEqual(report, want) {
+		for _, spending := range report {
+			t.Logf("%+v", spending)
+		}
+		t.Error("wrong report")
+	}
+	if report := AggregateSpending(nil, employees); len(report) != 0 {
+		t.Errorf("report without rides has %d employees", len(report))
+	}
+}
+
+func TestSpendingMessage(t *testing.T) {
+	period := MonthPeriod(time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC))
+	report := []*EmployeeSpending{
+		{Name: "Пётр Петров", Phone: "79000000002", Rides: 1, Minutes: 30, Cost: 500.5},
+		{Name: "Иван Иванов", Phone: "79000000001", Rides: 2, Minutes: 30, Cost: 300},
+	}
+	want := "Расходы сотрудников за 10.2021:\n" +
+		"\nПётр Петров (79000000002)\nПоездок: 1, минут: 30\nСумма: 500.50 ₽\n" +
+		"\nИван Иванов (79000000001)\nПоездок: 2, минут: 30\nСумма: 300.00 ₽\n" +
+		"\nИтого: 800.50 ₽"
+	if mes := SpendingMessage(report, period); mes != want {
+		t.Errorf("message:\n%s\nwant:\n%s", mes, want)
+	}
+
+	report = nil
+	for i := 0; i < 200; i++ {
+		report = append(report, &EmployeeSpending{Name: "Сотрудник " + strconv.Itoa(i), Phone: "7900000" + strconv.Itoa(1000+i), Rides: 1, Cost: 100})
+	}
+	mes := SpendingMessage(report, period)
+	if length := utf8.RuneCountInString(mes); length > 4096 {
+		t.Errorf("message of %d characters isn't accepted by Telegram", length)
+	}
+	if !strings.Contains(mes, "…и ещё сотрудников: ") || !strings.HasSuffix(mes, "\nИтого: 20000.00 ₽") {
+		t.Errorf("long message ends with:\n%s", mes[len(mes)-200:])
+	}
+}
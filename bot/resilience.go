@@ -0,0 +1,346 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	deli "github.com/fuksman/delimobil"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDelimobilUnavailable is shown to users instead of network errors.
+var ErrDelimobilUnavailable = errors.New("Делимобиль недоступен, попробуй позже")
+
+// ErrInvoiceUnconfirmed is returned when CreateInvoice has failed after the request could reach Delimobil.
+var ErrInvoiceUnconfirmed = errors.New("Делимобиль не подтвердил создание счёта, но мог его создать.\nПроверь «Последний счёт», прежде чем создавать новый")
+
+var errDelimobilTimeout = errors.New("delimobil request timed out")
+
+const (
+	delimobilBackoff = 500 * time.Millisecond
+	// Consecutive transient failures opening the circuit and the pause before the next try
+	breakerThreshold = 5
+	breakerCooldown  = time.Minute
+)
+
+// delimobilPolicy is how long to wait for the call and how many times to try it.
+type delimobilPolicy struct {
+	Timeout  time.Duration
+	Attempts int
+	// Unconfirmed is returned instead of ErrDelimobilUnavailable by the call changing Delimobil data,
+	// since it may have succeeded even if the answer is lost
+	Unconfirmed error
+}
+
+var defaultDelimobilPolicy = delimobilPolicy{Timeout: 15 * time.Second, Attempts: 3}
+
+var delimobilPolicies = map[string]delimobilPolicy{
+	"SetRides": {Timeout: 30 * time.Second, Attempts: 3},
+	// A retry may create one more invoice
+	"CreateInvoice":  {Timeout: time.Minute, Attempts: 1, Unconfirmed: ErrInvoiceUnconfirmed},
+	"LastFileByType": {Timeout: time.Minute, Attempts: 3},
+	"FileById":       {Timeout: time.Minute, Attempts: 3},
+}
+
+// isTransient reports if the call may succeed when repeated.
+// Delimobil answering with 5xx or 429 status is overloaded or broken, like when it doesn't answer at all.
+func isTransient(err error) bool {
+	if errors.Is(err, errDelimobilTimeout) {
+		return true
+	}
+	if status, ok := delimobilStatus(err); ok {
+		return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// CircuitBreaker stops calling Delimobil after several transient failures in a row.
+// After the cooldown a single call is let through, only its success closes the circuit.
+type CircuitBreaker struct {
+	log logrus.FieldLogger
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	open     bool
+	trying   bool
+}
+
+func NewCircuitBreaker(logger logrus.FieldLogger) *CircuitBreaker {
+	return &CircuitBreaker{log: logger}
+}
+
+// Open reports if calls are short-circuited now.
+func (breaker *CircuitBreaker) Open() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return breaker.open && (breaker.trying || time.Since(breaker.openedAt) < breakerCooldown)
+}
+
+// Allow reports if the call may be made.
+func (breaker *CircuitBreaker) Allow() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if !breaker.open {
+		return true
+	}
+	if breaker.trying || time.Since(breaker.openedAt) < breakerCooldown {
+		return false
+	}
+	breaker.trying = true
+	return true
+}
+
+// Succeed records the allowed call answered successfully, it closes the circuit.
+func (breaker *CircuitBreaker) Succeed() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.trying = false
+	if breaker.open {
+		breaker.log.Warn("Delimobil is available again for the company.")
+	}
+	breaker.open = false
+	breaker.failures = 0
+}
+
+// Fail records the transient failure of the allowed call.
+func (breaker *CircuitBreaker) Fail() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.trying = false
+	breaker.failures++
+	if breaker.open || breaker.failures >= breakerThreshold {
+		if !breaker.open {
+			breaker.log.Warn("Delimobil is unavailable for the company, pausing its requests.")
+		}
+		breaker.open = true
+		breaker.openedAt = time.Now()
+	}
+}
+
+// Release records the allowed call failed for other reasons, e.g. rejected by Delimobil or cancelled.
+// It's neither a success nor a failure, so only the next call may try again.
+func (breaker *CircuitBreaker) Release() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.trying = false
+}
+
+// CircuitBreakers keep a breaker per company, so failing calls of one company don't stop the others.
+type CircuitBreakers struct {
+	log *logrus.Logger
+
+	mu       sync.Mutex
+	breakers map[int]*CircuitBreaker
+}
+
+func NewCircuitBreakers(logger *logrus.Logger) *CircuitBreakers {
+	return &CircuitBreakers{log: logger, breakers: make(map[int]*CircuitBreaker)}
+}
+
+// For returns the breaker of the company.
+func (breakers *CircuitBreakers) For(companyId int) *CircuitBreaker {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	breaker, ok := breakers.breakers[companyId]
+	if !ok {
+		breaker = NewCircuitBreaker(breakers.log.WithField("companyId", companyId))
+		breakers.breakers[companyId] = breaker
+	}
+	return breaker
+}
+
+// AllOpen reports if calls of every company are short-circuited now, so Delimobil itself is unavailable.
+func (breakers *CircuitBreakers) AllOpen() bool {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	if len(breakers.breakers) == 0 {
+		return false
+	}
+	for _, breaker := range breakers.breakers {
+		if !breaker.Open() {
+			return false
+		}
+	}
+	return true
+}
+
+// ResilientDelimobil retries transient failures with backoff, limits calls in time
+// and short-circuits them while Delimobil is unavailable. Calls are observed for metrics, retries included.
+type ResilientDelimobil struct {
+	client   DelimobilClient
+	breakers *CircuitBreakers
+	metrics  *Metrics
+	log      *logrus.Logger
+}
+
+func NewResilientDelimobil(client DelimobilClient, breakers *CircuitBreakers, metrics *Metrics, logger *logrus.Logger) *ResilientDelimobil {
+	return &ResilientDelimobil{client: client, breakers: breakers, metrics: metrics, log: logger}
+}
+
+func (resilient *ResilientDelimobil) NewCompany(login, password string) *deli.Company {
+	return resilient.client.NewCompany(login, password)
+}
+
+// API binds the calls to ctx: waiting for them and the pauses between retries end when it's done.
+func (resilient *ResilientDelimobil) API(ctx context.Context, data *deli.Company) Delimobil {
+	return &resilientAPI{resilient: resilient, ctx: ctx, data: data}
+}
+
+type resilientAPI struct {
+	resilient *ResilientDelimobil
+	ctx       context.Context
+	data      *deli.Company
+}
+
+type callResult struct {
+	result interface{}
+	err    error
+}
+
+// attempt calls Delimobil with a copy of the company data, so a call left behind by the timeout
+// can't change the data, which is updated only on success.
+func (api *resilientAPI) attempt(timeout time.Duration, call func(Delimobil) (interface{}, error)) (interface{}, error) {
+	scratch := *api.data
+	done := make(chan callResult, 1)
+	go func() {
+		result, err := call(api.resilient.client.API(&scratch))
+		done <- callResult{result, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err == nil {
+			*api.data = scratch
+		}
+		return res.result, res.err
+	case <-time.After(timeout):
+		return nil, errDelimobilTimeout
+	case <-api.ctx.Done():
+		return nil, api.ctx.Err()
+	}
+}
+
+func (api *resilientAPI) call(method string, call func(Delimobil) (interface{}, error)) (result interface{}, err error) {
+	defer api.resilient.metrics.observeDelimobil(method, time.Now(), &err)
+	policy, ok := delimobilPolicies[method]
+	if !ok {
+		policy = defaultDelimobilPolicy
+	}
+	callLogger := api.resilient.log.WithField("companyId", api.data.Id).WithField("method", method)
+	breaker := api.resilient.breakers.For(api.data.Id)
+	for attempt := 1; ; attempt++ {
+		if !breaker.Allow() {
+			return nil, ErrDelimobilUnavailable
+		}
+		result, err = api.attempt(policy.Timeout, call)
+		switch {
+		case err == nil:
+			breaker.Succeed()
+			return result, nil
+		case isTransient(err):
+			breaker.Fail()
+		default:
+			breaker.Release()
+			return result, err
+		}
+		callLogger.Warn(err)
+		// A refused connection is the only failure when the request surely hasn't reached Delimobil
+		if policy.Unconfirmed != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, policy.Unconfirmed
+		}
+		if attempt >= policy.Attempts {
+			return nil, ErrDelimobilUnavailable
+		}
+		backoff := delimobilBackoff << (attempt - 1)
+		select {
+		case <-time.After(backoff + time.Duration(rand.Int63n(int64(backoff/2)))):
+		case <-api.ctx.Done():
+			return nil, api.ctx.Err()
+		}
+	}
+}
+
+func (api *resilientAPI) Authenticate() error {
+	_, err := api.call("Authenticate", func(d Delimobil) (interface{}, error) {
+		return nil, d.Authenticate()
+	})
+	return err
+}
+
+func (api *resilientAPI) SetInfo() error {
+	_, err := api.call("SetInfo", func(d Delimobil) (interface{}, error) {
+		return nil, d.SetInfo()
+	})
+	return err
+}
+
+func (api *resilientAPI) SetRides(count, page int) error {
+	_, err := api.call("SetRides", func(d Delimobil) (interface{}, error) {
+		return nil, d.SetRides(count, page)
+	})
+	return err
+}
+
+func (api *resilientAPI) SetEmployees() error {
+	_, err := api.call("SetEmployees", func(d Delimobil) (interface{}, error) {
+		return nil, d.SetEmployees()
+	})
+	return err
+}
+
+func (api *resilientAPI) HasEmployee(phone string) (bool, error) {
+	exist, err := api.call("HasEmployee", func(d Delimobil) (interface{}, error) {
+		return d.HasEmployee(phone)
+	})
+	if err != nil {
+		return false, err
+	}
+	return exist.(bool), nil
+}
+
+func (api *resilientAPI) file(method string, call func(Delimobil) (*deli.File, error)) (*deli.File, error) {
+	file, err := api.call(method, func(d Delimobil) (interface{}, error) {
+		return call(d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return file.(*deli.File), nil
+}
+
+func (api *resilientAPI) CreateInvoice(amount float64) (*deli.File, error) {
+	return api.file("CreateInvoice", func(d Delimobil) (*deli.File, error) {
+		return d.CreateInvoice(amount)
+	})
+}
+
+func (api *resilientAPI) LastFileByType(fileType string) (*deli.File, error) {
+	return api.file("LastFileByType", func(d Delimobil) (*deli.File, error) {
+		return d.LastFileByType(fileType)
+	})
+}
+
+func (api *resilientAPI) SetFiles() error {
+	_, err := api.call("SetFiles", func(d Delimobil) (interface{}, error) {
+		return nil, d.SetFiles()
+	})
+	return err
+}
+
+func (api *resilientAPI) FileById(id int) (*deli.File, error) {
+	return api.file("FileById", func(d Delimobil) (*deli.File, error) {
+		return d.FileById(id)
+	})
+}
@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestBreakerStates(t *testing.T) {
+	breaker := NewCircuitBreaker(logrus.New())
+	for i := 1; i < breakerThreshold; i++ {
+		breaker.Fail()
+	}
+	if breaker.Open() || !breaker.Allow() {
+		t.Fatal("breaker is open before the threshold")
+	}
+	// Errors other than transient ones are not successes, the failures in a row are still counted
+	breaker.Release()
+	breaker.Fail()
+	if !breaker.Open() || breaker.Allow() {
+		t.Fatal("breaker is closed after the threshold")
+	}
+
+	// After the cooldown a single call is let through
+	breaker.openedAt = time.Now().Add(-breakerCooldown)
+	if breaker.Open() || !breaker.Allow() {
+		t.Fatal("breaker doesn't let the call through after the cooldown")
+	}
+	if !breaker.Open() || breaker.Allow() {
+		t.Fatal("breaker lets another call through while trying")
+	}
+	breaker.Fail()
+	if !breaker.Open() || breaker.Allow() {
+		t.Fatal("breaker is closed after the failed try")
+	}
+
+	breaker.openedAt = time.Now().Add(-breakerCooldown)
+	if !breaker.Allow() {
+		t.Fatal("breaker doesn't let the call through after the cooldown")
+	}
+	breaker.Release()
+	if breaker.Open() || !breaker.Allow() {
+		t.Fatal("breaker doesn't let the next call try after the released one")
+	}
+	breaker.Succeed()
+	if breaker.Open() {
+		t.Fatal("breaker is open after the success")
+	}
+	for i := 1; i < breakerThreshold; i++ {
+		breaker.Fail()
+	}
+	if breaker.Open() {
+		t.Error("failures before the success are still counted")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{io.ErrUnexpectedEOF, true},
+		{syscall.ECONNRESET, true},
+		{errDelimobilTimeout, true},
+		{&DelimobilStatusError{Code: http.StatusInternalServerError, Err: errors.New("unexpected status 500")}, true},
+		{&DelimobilStatusError{Code: http.StatusBadGateway, Err: errors.New("bad gateway")}, true},
+		{&DelimobilStatusError{Code: http.StatusTooManyRequests, Err: errors.New("too many requests")}, true},
+		{&DelimobilStatusError{Code: http.StatusBadRequest, Err: errors.New("bad request")}, false},
+		{&DelimobilStatusError{Code: http.StatusUnauthorized, Err: errors.New("unauthorized")}, false},
+		{errors.New("документ не найден"), false},
+	} {
+		if got := isTransient(test.err); got != test.want {
+			t.Errorf("isTransient(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestRetriesStopWithContext(t *testing.T) {
+	fake := newFakeAcme()
+	app := newOfflineApp(t, fake)
+	ctx, cancel := context.WithCancel(context.Background())
+	company := app.NewCompany(ctx, "acme", "secret")
+
+	fake.SetError(1, &DelimobilStatusError{Code: http.StatusServiceUnavailable, Err: errors.New("service unavailable")})
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	if err := company.SetInfo(); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > delimobilBackoff {
+		t.Errorf("retries went on for %v after ctx is done", elapsed)
+	}
+}
+
+func TestBreakerPerCompany(t *testing.T) {
+	breakers := NewCircuitBreakers(logrus.New())
+	for i := 0; i < breakerThreshold; i++ {
+		breakers.For(1).Fail()
+	}
+	breakers.For(2).Succeed()
+	if !breakers.For(1).Open() {
+		t.Error("breaker of the failing company is closed")
+	}
+	if breakers.For(2).Open() {
+		t.Error("breaker of another company is open")
+	}
+	if breakers.AllOpen() {
+		t.Error("all breakers are open while one company works")
+	}
+
+	for i := 0; i < breakerThreshold; i++ {
+		breakers.For(2).Fail()
+	}
+	if !breakers.AllOpen() {
+		t.Error("not all breakers are open while every company fails")
+	}
+}
+
+func TestCreateInvoiceIsNotRepeated(t *testing.T) {
+	fake := newFakeAcme()
+	app := newOfflineApp(t, fake)
+	company := app.NewCompany(context.Background(), "acme", "secret")
+
+	fake.SetError(1, io.ErrUnexpectedEOF)
+	if _, err := company.CreateInvoice(1000); !errors.Is(err, ErrInvoiceUnconfirmed) {
+		t.Errorf("lost answer: err = %v, want %v", err, ErrInvoiceUnconfirmed)
+	}
+
+	fake.SetError(1, syscall.ECONNREFUSED)
+	if _, err := company.CreateInvoice(1000); !errors.Is(err, ErrDelimobilUnavailable) {
+		t.Errorf("refused connection: err = %v, want %v", err, ErrDelimobilUnavailable)
+	}
+
+	fake.SetError(1, nil)
+	if err := company.SetFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if len(company.Files) != 0 {
+		t.Errorf("%d invoices are created by failed calls", len(company.Files))
+	}
+}
@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"errors"
+	"time"
+
+	deli "github.com/fuksman/delimobil"
+)
+
+const ridesPageSize = 50
+
+// Period is a half-open time interval [From, To).
+type Period struct {
+	From time.Time
+	To   time.Time
+}
+
+func MonthPeriod(month time.Time) Period {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	return Period{From: from, To: from.AddDate(0, 1, 0)}
+}
+
+// RelativeMonthPeriod resolves "current" and "previous" used in inline buttons data.
+func RelativeMonthPeriod(month string) Period {
+	now := time.Now()
+	if month == "previous" {
+		now = now.AddDate(0, -1, 0)
+	}
+	return MonthPeriod(now)
+}
+
+func ParseMonthPeriod(month string) (Period, error) {
+	t, err := time.ParseInLocation("2006-01", month, time.Local)
+	if err != nil {
+		return Period{}, errors.New("период должен быть в формате ГГГГ-ММ, например 2021-10")
+	}
+	return MonthPeriod(t), nil
+}
+
+func (period Period) Contains(t time.Time) bool {
+	return !t.Before(period.From) && t.Before(period.To)
+}
+
+func (period Period) String() string {
+	return period.From.Format("01.2006")
+}
+
+// RidesForPeriod walks all pages of SetRides and collects the rides started within the period.
+// Rides are returned by Delimobil newest first, so walking stops at the first page reaching past the period start.
+func (app *App) RidesForPeriod(company *Company, period Period) (rides deli.Rides, err error) {
+	companyLogger := app.log.WithField("companyId", company.Id)
+	companyLogger.Trace("Collecting rides for " + period.String() + "...")
+	for page := 1; ; page++ {
+		if err := company.SetRides(ridesPageSize, page); err != nil {
+			companyLogger.Warn(err)
+			return nil, err
+		}
+		reachedStart := false
+		for _, ride := range company.Rides {
+			if period.Contains(ride.StartTime) {
+				rides = append(rides, ride)
+			}
+			if ride.StartTime.Before(period.From) {
+				reachedStart = true
+			}
+		}
+		if reachedStart || len(company.Rides) < ridesPageSize {
+			break
+		}
+	}
+	companyLogger.Trace("Collected rides: ", len(rides))
+	return rides, nil
+}
+
+// FindRide walks pages of SetRides until the ride with the id, nil is returned if there is no such ride.
+// Ride ids grow with time, so walking stops at the first page reaching past the id.
+func (app *App) FindRide(company *Company, rideId int) (*deli.Ride, error) {
+	for page := 1; ; page++ {
+		if err := company.SetRides(ridesPageSize, page); err != nil {
+			app.log.WithField("companyId", company.Id).Warn(err)
+			return nil, err
+		}
+		reachedId := false
+		for _, ride := range company.Rides {
+			if ride.Id == rideId {
+				return &ride, nil
+			}
+			if ride.Id < rideId {
+				reachedId = true
+			}
+		}
+		if reachedId || len(company.Rides) < ridesPageSize {
+			return nil, nil
+		}
+	}
+}
+
+func RidesTable(rides deli.Rides, tags map[int]string, name string) *Table {
+	table := &Table{
+		Name:   name,
+		Header: []string{"Дата", "Сотрудник", "Автомобиль", "Длительность, мин", "Расстояние, км", "Стоимость, ₽", "Цель поездки"},
+	}
+	for _, ride := range rides {
+		table.AddRow(ride.StartTime.Format("02.01.2006 15:04"), ride.Employee, ride.Car, ride.Duration, ride.Distance, ride.Cost, tags[ride.Id])
+	}
+	return table
+}
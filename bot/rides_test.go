@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	deli "github.com/fuksman/delimobil"
+)
+
+func TestRidesForPeriod(t *testing.T) {
+	fake := newFakeAcme()
+	app := newOfflineApp(t, fake)
+	company := app.NewCompany(context.Background(), "acme", "secret")
+
+	// A ride every 6 hours from September to November, more than a few pages
+	start := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	id := 0
+	for ride := start; ride.Before(time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)); ride = ride.Add(6 * time.Hour) {
+		id++
+		fake.AddRide(1, deli.Ride{Id: id, StartTime: ride, Cost: 100})
+	}
+
+	rides, err := app.RidesForPeriod(company, MonthPeriod(time.Date(2021, 10, 15, 0, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rides) != 31*4 {
+		t.Fatalf("%d rides, want %d", len(rides), 31*4)
+	}
+	first, last := rides[len(rides)-1].StartTime, rides[0].StartTime
+	if !first.Equal(time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)) || !last.Equal(time.Date(2021, 10, 31, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("rides from %v to %v", first, last)
+	}
+
+	if rides, err := app.RidesForPeriod(company, MonthPeriod(time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC))); err != nil || len(rides) != 0 {
+		t.Errorf("rides before the first one: %d, err %v", len(rides), err)
+	}
+}
+
+func TestRidesTable(t *testing.T) {
+	rides := deli.Rides{
+		{Id: 2, StartTime: time.Date(2021, 10, 2, 9, 5, 0, 0, time.UTC), Employee: "Иванов", Car: "Kia Rio", Duration: 15, Distance: 7.25, Cost: 180},
+		{Id: 1, StartTime: time.Date(2021, 10, 1, 18, 30, 0, 0, time.UTC), Employee: "Петров", Car: "VW Polo", Duration: 40, Distance: 21, Cost: 450.5},
+	}
+	table := RidesTable(rides, map[int]string{1: "Офис"}, "rides-2021-10")
+	want := [][]interface{}{
+		{"02.10.2021 09:05", "Иванов", "Kia Rio", 15, 7.25, 180.0, ""},
+		{"01.10.2021 18:30", "Петров", "VW Polo", 40, 21.0, 450.5, "Офис"},
+	}
+	if !reflect.DeepEqual(table.Rows, want) {
+		t.Errorf("rows = %v, want %v", table.Rows, want)
+	}
+	if len(table.Header) != len(want[0]) {
+		t.Errorf("%d columns in header, %d in rows", len(table.Header), len(want[0]))
+	}
+}
+
+func TestParseMonthPeriod(t *testing.T) {
+	period, err := ParseMonthPeriod("2021-12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !period.Contains(time.Date(2021, 12, 31, 23, 59, 0, 0, time.Local)) || period.Contains(time.Date(2022, 1, 1, 0, 0, 0, 0, time.Local)) || period.Contains(time.Date(2021, 11, 30, 23, 59, 0, 0, time.Local)) {
+		t.Errorf("period = %v", period)
+	}
+	if _, err := ParseMonthPeriod("12.2021"); err == nil {
+		t.Error("month in wrong format is parsed")
+	}
+}
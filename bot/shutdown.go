@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+const (
+	// How long the shutdown waits for handlers and the notifier before closing the storage anyway.
+	shutdownTimeout = 30 * time.Second
+	// How long a single update may be handled, including all its storage requests
+	updateTimeout = 2 * time.Minute
+)
+
+// serve receives updates until ctx is done and handles each of them in its own goroutine.
+// The goroutine is counted before it starts, so the shutdown waits for every update received.
+// The bot is synchronous, so the handler is finished when ProcessUpdate returns.
+func (app *App) serve(ctx context.Context) {
+	stop := make(chan struct{})
+	go app.poller.Poll(app.bot, app.bot.Updates, stop)
+	for {
+		select {
+		case update := <-app.bot.Updates:
+			app.inFlight.Add(1)
+			go func() {
+				defer app.inFlight.Done()
+				app.bot.ProcessUpdate(update)
+			}()
+		case <-ctx.Done():
+			close(stop)
+			return
+		}
+	}
+}
+
+// withUpdateContext gives the handler one context with a deadline for the whole update.
+// It's derived from the app context, not cancelled on shutdown, so the handler can save its results.
+func (app *App) withUpdateContext(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(tlg tele.Context) error {
+		ctx, cancel := context.WithTimeout(app.ctx, updateTimeout)
+		defer cancel()
+		tlg.Set("ctx", ctx)
+		return next(tlg)
+	}
+}
+
+// UpdateContext returns the context of the update being handled.
+func UpdateContext(tlg tele.Context) context.Context {
+	if ctx, ok := tlg.Get("ctx").(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// background runs f in a goroutine which the shutdown waits for.
+// The work outlives the update, so it gets the app context instead.
+func (app *App) background(f func(ctx context.Context)) {
+	app.inFlight.Add(1)
+	go func() {
+		defer app.inFlight.Done()
+		f(app.ctx)
+	}()
+}
+
+// GracefulPoller allows to wait until the poller is actually stopped after serve closes its stop channel.
+type GracefulPoller struct {
+	tele.Poller
+	done chan struct{}
+}
+
+func NewGracefulPoller(poller tele.Poller) *GracefulPoller {
+	return &GracefulPoller{Poller: poller, done: make(chan struct{})}
+}
+
+// Register registers the poller given if it needs it.
+func (p *GracefulPoller) Register(b *tele.Bot) error {
+	if registrar, ok := p.Poller.(Registrar); ok {
+		return registrar.Register(b)
+	}
+	return nil
+}
+
+func (p *GracefulPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	defer close(p.done)
+	p.Poller.Poll(b, dest, stop)
+}
+
+// waitTimeout waits for all the channels to be closed but not longer than timeout.
+func waitTimeout(timeout time.Duration, done ...<-chan struct{}) bool {
+	deadline := time.After(timeout)
+	for _, ch := range done {
+		select {
+		case <-ch:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+// Shutdown stops the monitoring server, waits for the poller, the background loops and in-flight handlers and closes the storage.
+func (app *App) Shutdown(loopsDone ...<-chan struct{}) {
+	app.log.Info("Shutting down...")
+	if app.monitoring != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := app.monitoring.Shutdown(shutdownCtx); err != nil {
+			app.log.Warn("Can't stop monitoring server: ", err)
+		}
+	}
+	handlersDone := make(chan struct{})
+	go func() {
+		app.inFlight.Wait()
+		close(handlersDone)
+	}()
+	done := append([]<-chan struct{}{app.poller.done, handlersDone}, loopsDone...)
+	if !waitTimeout(shutdownTimeout, done...) {
+		app.log.Warn("Shutdown timeout exceeded, some work is interrupted.")
+	}
+	if err := app.client.Close(); err != nil {
+		app.log.Warn("Can't close firestore client: ", err)
+	}
+	app.log.Info("Stopped.")
+}
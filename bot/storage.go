@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+const storageTimeout = 10 * time.Second
+
+// storageContext limits a single storage request in time within the deadline of the update or the notifier.
+// Their contexts are not cancelled on shutdown, so the pending saves are flushed before the client is closed.
+func (app *App) storageContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, storageTimeout)
+}
+
+func (app *App) collection(name string) *firestore.CollectionRef {
+	return app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection(name)
+}
+
+func encodeGob(v interface{}) (string, error) {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// saveGob stores v gob-encoded under the "gob" field along with plain fields used in queries.
+func (app *App) saveGob(ctx context.Context, docRef *firestore.DocumentRef, v interface{}, fields map[string]interface{}) error {
+	encoded, err := encodeGob(v)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{"gob": encoded}
+	for key, val := range fields {
+		data[key] = val
+	}
+	reqCtx, cancel := app.storageContext(ctx)
+	_, err = docRef.Set(reqCtx, data)
+	cancel()
+	return err
+}
+
+func decodeGob(doc *firestore.DocumentSnapshot, v interface{}) error {
+	docGob, err := doc.DataAt("gob")
+	if err != nil {
+		return err
+	}
+	by, err := base64.StdEncoding.DecodeString(docGob.(string))
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(by)).Decode(v)
+}
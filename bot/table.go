@@ -0,0 +1,237 @@
+package bot
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	deli "github.com/fuksman/delimobil"
+)
+
+// Table is a simple spreadsheet: a header row and rows of string, int or float64 cells.
+type Table struct {
+	Name   string
+	Header []string
+	Rows   [][]interface{}
+}
+
+func (table *Table) AddRow(cells ...interface{}) {
+	table.Rows = append(table.Rows, cells)
+}
+
+func formatCell(cell interface{}) string {
+	switch val := cell.(type) {
+	case string:
+		return val
+	case int:
+		return strconv.Itoa(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', 2, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// CSV renders the table with ';' separator and UTF-8 BOM, so it opens in Excel with russian locale as is.
+func (table *Table) CSV() (*deli.File, error) {
+	buf := bytes.Buffer{}
+	buf.WriteString("\uFEFF")
+	w := csv.NewWriter(&buf)
+	w.Comma = ';'
+	if err := w.Write(table.Header); err != nil {
+		return nil, err
+	}
+	for _, row := range table.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = formatCell(cell)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return &deli.File{Data: &buf, FileName: table.Name + ".csv", MIME: "text/csv"}, nil
+}
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+)
+
+// XLSX renders the table as a single-sheet Office Open XML workbook.
+func (table *Table) XLSX() (*deli.File, error) {
+	sheet := bytes.Buffer{}
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	header := make([]interface{}, len(table.Header))
+	for i, title := range table.Header {
+		header[i] = title
+	}
+	for _, row := range append([][]interface{}{header}, table.Rows...) {
+		sheet.WriteString("<row>")
+		for _, cell := range row {
+			switch cell.(type) {
+			case int, float64:
+				sheet.WriteString(`<c t="n"><v>` + formatCell(cell) + `</v></c>`)
+			default:
+				sheet.WriteString(`<c t="inlineStr"><is><t>`)
+				if err := xml.EscapeText(&sheet, []byte(formatCell(cell))); err != nil {
+					return nil, err
+				}
+				sheet.WriteString(`</t></is></c>`)
+			}
+		}
+		sheet.WriteString("</row>")
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	for _, part := range []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", xlsxWorkbook},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/worksheets/sheet1.xml", sheet.String()},
+	} {
+		w, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return &deli.File{Data: &buf, FileName: table.Name + ".xlsx", MIME: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}, nil
+}
+
+type xlsxSharedStrings struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// ReadXLSX reads cells of the first worksheet as strings, shared and inline strings resolved.
+func ReadXLSX(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	parts := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	readPart := func(name string, v interface{}) error {
+		f, ok := parts[name]
+		if !ok {
+			return fmt.Errorf("в файле нет %s", name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return xml.NewDecoder(r).Decode(v)
+	}
+
+	var shared []string
+	if _, ok := parts["xl/sharedStrings.xml"]; ok {
+		sst := xlsxSharedStrings{}
+		if err := readPart("xl/sharedStrings.xml", &sst); err != nil {
+			return nil, err
+		}
+		for _, item := range sst.Items {
+			text := item.Text
+			for _, run := range item.Runs {
+				text += run.Text
+			}
+			shared = append(shared, text)
+		}
+	}
+
+	sheet := xlsxSheet{}
+	if err := readPart("xl/worksheets/sheet1.xml", &sheet); err != nil {
+		return nil, err
+	}
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, sheetRow := range sheet.Rows {
+		var row []string
+		for _, cell := range sheetRow.Cells {
+			col := len(row)
+			if cell.Ref != "" {
+				col = xlsxColumn(cell.Ref)
+			}
+			if col < 0 || col >= xlsxMaxColumns {
+				return nil, fmt.Errorf("неверная ссылка на ячейку %s", cell.Ref)
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+			switch cell.Type {
+			case "s":
+				i, err := strconv.Atoi(cell.Value)
+				if err != nil || i < 0 || i >= len(shared) {
+					return nil, fmt.Errorf("неверная ссылка на строку в ячейке %s", cell.Ref)
+				}
+				row[col] = shared[i]
+			case "inlineStr":
+				row[col] = cell.Inline
+			default:
+				row[col] = cell.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Columns of a worksheet, the last one is XFD
+const xlsxMaxColumns = 16384
+
+// xlsxColumn returns zero-based column index of the cell reference like "AB12" or "$AB$12",
+// or -1 if the reference has no column.
+func xlsxColumn(ref string) int {
+	col := 0
+	for i, r := range strings.TrimPrefix(ref, "$") {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		// Longer references are out of the worksheet anyway, the index isn't let to overflow
+		if i == 3 {
+			return xlsxMaxColumns
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col - 1
+}
@@ -0,0 +1,144 @@
+package bot
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func testTable() *Table {
+	table := &Table{Name: "report", Header: []string{"Сотрудник", "Поездки", "Сумма"}}
+	table.AddRow("Иванов; Иван", 3, 1234.5)
+	table.AddRow(`"Петров" <П>`, 0, 0.0)
+	return table
+}
+
+func TestTableCSV(t *testing.T) {
+	file, err := testTable().CSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(file.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\uFEFFСотрудник;Поездки;Сумма\n" +
+		"\"Иванов; Иван\";3;1234.50\n" +
+		"\"\"\"Петров\"\" <П>\";0;0.00\n"
+	if string(data) != want {
+		t.Errorf("csv:\n%q\nwant:\n%q", data, want)
+	}
+	if file.FileName != "report.csv" {
+		t.Errorf("file name = %q", file.FileName)
+	}
+}
+
+func TestTableXLSXRoundTrip(t *testing.T) {
+	file, err := testTable().XLSX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(file.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ReadXLSX(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Сотрудник", "Поездки", "Сумма"},
+		{"Иванов; Иван", "3", "1234.50"},
+		{`"Петров" <П>`, "0", "0.00"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+}
+
+// xlsxWith builds the workbook of the sheet and shared strings given.
+func xlsxWith(t *testing.T, sheet, sharedStrings string) []byte {
+	t.Helper()
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	parts := map[string]string{"xl/worksheets/sheet1.xml": sheet}
+	if sharedStrings != "" {
+		parts["xl/sharedStrings.xml"] = sharedStrings
+	}
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadXLSX(t *testing.T) {
+	shared := `<sst><si><t>Дата</t></si><si><r><t>Сум</t></r><r><t>ма</t></r></si></sst>`
+	for _, test := range []struct {
+		name  string
+		sheet string
+		want  [][]string
+		ok    bool
+	}{
+		{
+			"shared strings and gaps",
+			`<worksheet><sheetData><row><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row><row><c r="B2"><v>5000</v></c></row></sheetData></worksheet>`,
+			[][]string{{"Дата", "", "Сумма"}, {"", "5000"}},
+			true,
+		},
+		{
+			"absolute references",
+			`<worksheet><sheetData><row><c r="$A$1" t="inlineStr"><is><t>Дата</t></is></c><c r="$B$1"><v>1</v></c></row></sheetData></worksheet>`,
+			[][]string{{"Дата", "1"}},
+			true,
+		},
+		{
+			"references without column",
+			`<worksheet><sheetData><row><c r="12"><v>1</v></c></row></sheetData></worksheet>`,
+			nil,
+			false,
+		},
+		{
+			"references out of the sheet",
+			`<worksheet><sheetData><row><c r="ZZZZZZZZZZZZZZ1"><v>1</v></c></row></sheetData></worksheet>`,
+			nil,
+			false,
+		},
+		{
+			"wrong shared string",
+			`<worksheet><sheetData><row><c r="A1" t="s"><v>7</v></c></row></sheetData></worksheet>`,
+			nil,
+			false,
+		},
+	} {
+		rows, err := ReadXLSX(xlsxWith(t, test.sheet, shared))
+		if (err == nil) != test.ok || !reflect.DeepEqual(rows, test.want) {
+			t.Errorf("%s: rows = %q, err = %v", test.name, rows, err)
+		}
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for ref, want := range map[string]int{
+		"A1":     0,
+		"Z9":     25,
+		"AA10":   26,
+		"AB12":   27,
+		"$AB$12": 27,
+		"XFD1":   16383,
+		"12":     -1,
+		"":       -1,
+	} {
+		if got := xlsxColumn(ref); got != want {
+			t.Errorf("xlsxColumn(%q) = %d, want %d", ref, got, want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+// RideTag is the purpose or cost center of the ride given by the employee.
+type RideTag struct {
+	RideId    int
+	CompanyId int
+	UserId    int64
+	Purpose   string
+}
+
+func rideTagDocId(companyId, rideId int) string {
+	return strconv.Itoa(companyId) + "-" + strconv.Itoa(rideId)
+}
+
+func (app *App) SaveRideTag(ctx context.Context, tag *RideTag) error {
+	tagLogger := app.log.WithField("companyId", tag.CompanyId).WithField("rideId", tag.RideId)
+	tagLogger.Trace("Saving ride tag...")
+	tagDocRef := app.collection("rideTags").Doc(rideTagDocId(tag.CompanyId, tag.RideId))
+	if err := app.saveGob(ctx, tagDocRef, tag, map[string]interface{}{"companyId": tag.CompanyId}); err != nil {
+		tagLogger.Warn(err)
+		return err
+	}
+
+	tagLogger.Info("Saved!")
+	return nil
+}
+
+// LoadRideTags returns purposes of the company rides by ride id.
+func (app *App) LoadRideTags(ctx context.Context, companyId int) (tags map[int]string, err error) {
+	companyLogger := app.log.WithField("companyId", companyId)
+	companyLogger.Trace("Loading ride tags...")
+	reqCtx, cancel := app.storageContext(ctx)
+	tagDocs, err := app.collection("rideTags").Where("companyId", "==", companyId).Documents(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		companyLogger.Warn(err)
+		return nil, err
+	}
+
+	tags = make(map[int]string)
+	for _, tagDoc := range tagDocs {
+		tag := RideTag{}
+		if err := decodeGob(tagDoc, &tag); err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		tags[tag.RideId] = tag.Purpose
+	}
+
+	companyLogger.Info("Loaded ride tags: ", len(tags))
+	return tags, nil
+}
+
+func (app *App) SetCostCenters(ctx context.Context, company *Company, list string) error {
+	var costCenters []string
+	for _, costCenter := range strings.Split(list, ",") {
+		if costCenter = strings.TrimSpace(costCenter); costCenter != "" {
+			costCenters = append(costCenters, costCenter)
+		}
+	}
+	if err := app.UpdateCompany(ctx, company.Id, func(saved *Company) {
+		saved.CostCenters = costCenters
+	}); err != nil {
+		return err
+	}
+	company.CostCenters = costCenters
+	return nil
+}
+
+// costCenterId identifies the cost center in the button data by its text, which may not fit the 64 bytes Telegram allows.
+// The position in the list isn't used, so the button stays right after the list is changed.
+func costCenterId(costCenter string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(costCenter))), 36)
+}
+
+func (app *App) RidePurposeMenu(company *Company, rideId int) *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	rows := make([]tele.Row, 0, len(company.CostCenters))
+	for _, costCenter := range company.CostCenters {
+		rows = append(rows, menu.Row(menu.Data(costCenter, app.btnRidePurpose.Unique, strconv.Itoa(rideId), costCenterId(costCenter))))
+	}
+	menu.Inline(rows...)
+	return menu
+}
+
+func (app *App) CostCenters(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	_, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	if list := (*tlg).Data(); list != "" {
+		if list == "-" {
+			list = ""
+		}
+		if err := app.SetCostCenters(ctx, company, list); err != nil {
+			return (*tlg).Send(err.Error(), menu)
+		}
+	}
+	if len(company.CostCenters) == 0 {
+		return (*tlg).Send("Список целей поездок пуст, сотрудники не получают вопросов о поездках.\nЗадать список: /costcenters Встреча с клиентом, Командировка, Офис", menu)
+	}
+	return (*tlg).Send("После каждой поездки сотрудник выбирает одну из целей:\n• "+strings.Join(company.CostCenters, "\n• ")+
+		"\n\nИзменить список: /costcenters цель 1, цель 2\nОчистить: /costcenters -", menu)
+}
+
+func (app *App) TagRide(tlg *tele.Context, rideId int, purpose string) error {
+	ctx := UpdateContext(*tlg)
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	if purpose == "" {
+		return (*tlg).Send("Цель поездки не может быть пустой", menu)
+	}
+	// Admins may set the purpose of any ride, employees only of their own ones
+	if permissions, _ := (*tlg).Get("permissions").(string); permissions != "admin" {
+		ride, err := app.FindRide(company, rideId)
+		if err != nil {
+			return (*tlg).Send(err.Error(), menu)
+		}
+		if ride == nil || NormalizePhone(ride.Phone) != NormalizePhone(user.Phone) {
+			return (*tlg).Send("Не нашёл твою поездку с номером "+strconv.Itoa(rideId), menu)
+		}
+	}
+	tag := &RideTag{RideId: rideId, CompanyId: company.Id, UserId: user.Id, Purpose: purpose}
+	if err := app.SaveRideTag(ctx, tag); err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	return (*tlg).Send("✅ Записал цель поездки: "+purpose, menu)
+}
+
+// PurposeFromButton resolves the callback data of the ride purpose button to the ride id and purpose.
+func PurposeFromButton(company *Company, args []string) (rideId int, purpose string, err error) {
+	if len(args) != 2 {
+		return 0, "", errors.New("не могу разобрать ответ")
+	}
+	rideId, err = strconv.Atoi(args[0])
+	if err != nil {
+		return 0, "", err
+	}
+	for _, costCenter := range company.CostCenters {
+		if costCenterId(costCenter) == args[1] {
+			return rideId, costCenter, nil
+		}
+	}
+	return 0, "", errors.New("такой цели поездки уже нет в списке")
+}
+
+func (app *App) NotifyAboutNewRides(ctx context.Context, b Sender) {
+	app.log.Trace("Asking users about new rides...")
+	reqCtx, cancel := app.storageContext(ctx)
+	companyDocRefs, err := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("companies").DocumentRefs(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		app.log.Warn(err)
+		return
+	}
+
+	for _, companyDocRef := range companyDocRefs {
+		companyId, err := strconv.Atoi(companyDocRef.ID)
+		if err != nil {
+			app.log.Warn(err)
+			continue
+		}
+
+		companyLogger := app.log.WithField("companyId", companyId)
+		company, err := app.readCompany(ctx, companyId)
+		if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		// Companies without cost centers aren't asked about rides, so they aren't authenticated
+		if len(company.CostCenters) == 0 {
+			continue
+		}
+		if err := app.AuthenticateCompany(ctx, company); errors.Is(err, ErrReauthRequired) {
+			continue
+		} else if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		if err := company.SetRides(ridesPageSize, 1); err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+
+		lastRideId := company.LastRideId
+		for _, ride := range company.Rides {
+			if ride.Id > lastRideId {
+				lastRideId = ride.Id
+			}
+		}
+		if lastRideId == company.LastRideId {
+			continue
+		}
+		// The first check only remembers where we are, not to ask about the whole history.
+		if company.LastRideId != 0 {
+			users, err := app.LoadCompanyUsers(ctx, company.Id)
+			if err != nil {
+				companyLogger.Warn(err)
+				continue
+			}
+			for _, ride := range company.Rides {
+				if ride.Id <= company.LastRideId {
+					continue
+				}
+				for _, user := range users {
+					if NormalizePhone(user.Phone) != NormalizePhone(ride.Phone) {
+						continue
+					}
+					mes := "🚗 Какая цель поездки " + ride.StartTime.Format("02.01.2006 15:04") + " на " + ride.Car + "?\n" +
+						"Свой вариант: /purpose " + strconv.Itoa(ride.Id) + " текст"
+					if _, err := b.Send(user, mes, app.RidePurposeMenu(company, ride.Id)); err != nil {
+						companyLogger.Warn(err)
+					}
+				}
+			}
+		}
+		app.UpdateCompany(ctx, company.Id, func(saved *Company) {
+			saved.LastRideId = lastRideId
+		})
+	}
+	app.log.Trace("Asked users about new rides")
+}
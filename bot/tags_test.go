@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPurposeFromButton(t *testing.T) {
+	app := newOfflineApp(t, newFakeAcme())
+	company := &Company{CostCenters: []string{"Встреча с клиентом", "Командировка", "Офис"}}
+	menu := app.RidePurposeMenu(company, 42)
+	buttons := make(map[string][]string)
+	for _, row := range menu.InlineKeyboard {
+		for _, button := range row {
+			if data := "\f" + button.Unique + "|" + button.Data; len(data) > 64 {
+				t.Errorf("data of %q is %d bytes long", button.Text, len(data))
+			}
+			buttons[button.Text] = strings.Split(button.Data, "|")
+		}
+	}
+
+	// The list is changed after the question is sent
+	company.CostCenters = []string{"Офис", "Встреча с клиентом"}
+	for _, test := range []struct {
+		button  string
+		purpose string
+		ok      bool
+	}{
+		{"Встреча с клиентом", "Встреча с клиентом", true},
+		{"Офис", "Офис", true},
+		{"Командировка", "", false},
+	} {
+		rideId, purpose, err := PurposeFromButton(company, buttons[test.button])
+		if (err == nil) != test.ok || purpose != test.purpose || test.ok && rideId != 42 {
+			t.Errorf("%q: ride %d, purpose %q, err %v", test.button, rideId, purpose, err)
+		}
+	}
+	if _, _, err := PurposeFromButton(company, []string{"42"}); err == nil {
+		t.Error("data without the purpose is accepted")
+	}
+}
+
+func TestRidesOfCompanyWithoutCostCentersAreNotChecked(t *testing.T) {
+	h := newTestHarness(t)
+	addAcme(h)
+	signIn(t, h)
+	ctx := context.Background()
+
+	// Rejected credentials would be noticed by the authentication
+	h.Delimobil.SetPassword(1, "changed")
+	h.App.NotifyAboutNewRides(ctx, h.App.outbox)
+	h.App.outbox.Flush(ctx)
+	if texts := h.Texts(adminId); texts != "" {
+		t.Errorf("company without cost centers is authenticated:\n%s", texts)
+	}
+
+	company, err := h.App.readCompany(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.App.SetCostCenters(ctx, company, "Офис"); err != nil {
+		t.Fatal(err)
+	}
+	h.App.NotifyAboutNewRides(ctx, h.App.outbox)
+	h.App.outbox.Flush(ctx)
+	if texts := h.Texts(adminId); !strings.HasPrefix(texts, "🔑 Делимобиль не принимает логин и пароль компании") {
+		t.Errorf("company with cost centers isn't authenticated:\n%s", texts)
+	}
+}
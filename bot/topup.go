@@ -0,0 +1,241 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+const (
+	topUpPending = "pending"
+	// The invoice of the approved request is being created
+	topUpApproving = "approving"
+	topUpApproved  = "approved"
+	topUpRejected  = "rejected"
+)
+
+// TopUpRequest is an employee's request to top up the company balance.
+type TopUpRequest struct {
+	Id        string
+	CompanyId int
+	UserId    int64
+	Amount    float64
+	Reason    string
+	Status    string
+	CreatedAt time.Time
+	DecidedBy int64
+}
+
+func (app *App) SaveTopUpRequest(ctx context.Context, request *TopUpRequest) error {
+	requestLogger := app.log.WithField("companyId", request.CompanyId).WithField("topUpRequestId", request.Id)
+	requestLogger.Trace("Saving top-up request...")
+	if err := app.saveGob(ctx, app.collection("topUpRequests").Doc(request.Id), request, map[string]interface{}{"companyId": request.CompanyId}); err != nil {
+		requestLogger.Warn(err)
+		return err
+	}
+	requestLogger.Info("Saved!")
+	return nil
+}
+
+func (app *App) LoadTopUpRequest(ctx context.Context, id string) (request *TopUpRequest, err error) {
+	requestLogger := app.log.WithField("topUpRequestId", id)
+	requestLogger.Trace("Loading top-up request...")
+	reqCtx, cancel := app.storageContext(ctx)
+	requestDoc, err := app.collection("topUpRequests").Doc(id).Get(reqCtx)
+	cancel()
+	if err != nil {
+		requestLogger.Warn(err)
+		return nil, err
+	}
+	request = &TopUpRequest{}
+	if err := decodeGob(requestDoc, request); err != nil {
+		requestLogger.Warn(err)
+		return nil, err
+	}
+	requestLogger.Info("Loaded!")
+	return request, nil
+}
+
+func (app *App) TopUpRequestMenu(request *TopUpRequest) *tele.ReplyMarkup {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data("Создать счёт", app.btnApproveTopUp.Unique, request.Id),
+		menu.Data("Отклонить", app.btnRejectTopUp.Unique, request.Id),
+	))
+	return menu
+}
+
+func (app *App) AskTopUpAmount(tlg *tele.Context) error {
+	if _, err := app.StartDialog(*tlg, "topUp", "amount"); err != nil {
+		return (*tlg).Send(err.Error())
+	}
+	return (*tlg).Send("На какую сумму нужно пополнить баланс?\nОт "+strconv.Itoa(minInvoiceAmount)+" до "+strconv.Itoa(maxInvoiceAmount)+" ₽", app.cancelMenu)
+}
+
+func (app *App) ReceiveTopUpAmount(tlg tele.Context, state *DialogState) error {
+	ctx := UpdateContext(tlg)
+	amount, err := ParseInvoiceAmount(tlg.Text())
+	if err != nil {
+		return tlg.Send(err.Error()+"\nПопробуй ещё раз или нажми «Отмена»", app.cancelMenu)
+	}
+	state.SetFloat("amount", amount)
+	if err := app.NextStep(ctx, state, "reason"); err != nil {
+		return tlg.Send(err.Error(), app.cancelMenu)
+	}
+	return tlg.Send("Зачем нужно пополнение? Администратор увидит это объяснение", app.cancelMenu)
+}
+
+func (app *App) ReceiveTopUpReason(tlg tele.Context, state *DialogState) error {
+	ctx := UpdateContext(tlg)
+	user, company, menu, err := ReadContext(tlg)
+	if err != nil {
+		return tlg.Send(err.Error(), app.startMenu)
+	}
+	reason := strings.TrimSpace(tlg.Text())
+	if reason == "" {
+		return tlg.Send("Напиши причину текстом или нажми «Отмена»", app.cancelMenu)
+	}
+
+	request := &TopUpRequest{
+		Id:        strconv.Itoa(company.Id) + "-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		CompanyId: company.Id,
+		UserId:    user.Id,
+		Amount:    state.Float("amount"),
+		Reason:    reason,
+		Status:    topUpPending,
+		CreatedAt: time.Now(),
+	}
+	if err := app.SaveTopUpRequest(ctx, request); err != nil {
+		return tlg.Send(err.Error(), app.cancelMenu)
+	}
+	if err := app.FinishDialog(ctx, state); err != nil {
+		return tlg.Send(err.Error(), menu)
+	}
+
+	users, err := app.LoadCompanyUsers(ctx, company.Id)
+	if err != nil {
+		return tlg.Send(err.Error(), menu)
+	}
+	sent := 0
+	mes := "💰 Запрос на пополнение баланса " + company.Info.Name + " на " + FormatRubles(request.Amount) + "\n" +
+		"От: +" + NormalizePhone(user.Phone) + "\n" +
+		"Причина: " + request.Reason
+	for _, admin := range users {
+		if !admin.Admin {
+			continue
+		}
+		// Queued, so the admins who have blocked the bot are deactivated by the outbox
+		if _, err := app.outbox.Send(admin, mes, app.TopUpRequestMenu(request)); err != nil {
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return tlg.Send("Не смог отправить запрос: у компании нет администраторов в боте", menu)
+	}
+	return tlg.Send("✅ Отправил запрос администратору, сообщу о решении", menu)
+}
+
+var errTopUpDecided = errors.New("по этому запросу уже принято решение")
+
+// moveTopUpRequest changes the status of the company request in a transaction,
+// so the request is decided only once even if several admins press the buttons at once.
+func (app *App) moveTopUpRequest(ctx context.Context, id string, companyId int, from, to string, decidedBy int64) (request *TopUpRequest, err error) {
+	requestLogger := app.log.WithField("companyId", companyId).WithField("topUpRequestId", id)
+	requestLogger.Trace("Moving top-up request from " + from + " to " + to + "...")
+	requestDocRef := app.collection("topUpRequests").Doc(id)
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	err = app.client.RunTransaction(reqCtx, func(ctx context.Context, tx *firestore.Transaction) error {
+		requestDoc, err := tx.Get(requestDocRef)
+		if err != nil {
+			return err
+		}
+		request = &TopUpRequest{}
+		if err := decodeGob(requestDoc, request); err != nil {
+			return err
+		}
+		if request.CompanyId != companyId {
+			return errors.New("не могу найти этот запрос")
+		}
+		if request.Status != from {
+			return errTopUpDecided
+		}
+		request.Status = to
+		request.DecidedBy = decidedBy
+		encoded, err := encodeGob(request)
+		if err != nil {
+			return err
+		}
+		return tx.Set(requestDocRef, map[string]interface{}{"gob": encoded, "companyId": request.CompanyId})
+	})
+	if err != nil {
+		requestLogger.Warn(err)
+		return nil, err
+	}
+	requestLogger.Info("Moved!")
+	return request, nil
+}
+
+// notifyRequester tells the employee about the decision on the request through the outbox.
+func (app *App) notifyRequester(ctx context.Context, request *TopUpRequest, mes string) {
+	requester, err := app.LoadUser(ctx, request.UserId)
+	if err != nil {
+		return
+	}
+	app.outbox.Send(requester, mes)
+}
+
+// ApproveTopUp creates the invoice for the request, it's approved only if the invoice is created.
+func (app *App) ApproveTopUp(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	id := (*tlg).Data()
+	request, err := app.moveTopUpRequest(ctx, id, company.Id, topUpPending, topUpApproving, user.Id)
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+
+	record, invoiceErr := app.CreateInvoice(tlg, request.Amount)
+	if record == nil {
+		// Other admins may try again, after checking the invoice isn't created if it's unknown
+		if errors.Is(invoiceErr, ErrInvoiceUnconfirmed) {
+			if _, err := app.moveTopUpRequest(ctx, id, company.Id, topUpApproving, topUpPending, 0); err != nil {
+				app.log.WithField("topUpRequestId", id).Warn(err)
+			}
+			return (*tlg).Send(invoiceErr.Error()+"\nЗапрос остался в ожидании", menu)
+		}
+		if _, err := app.moveTopUpRequest(ctx, id, company.Id, topUpApproving, topUpPending, 0); err != nil {
+			return (*tlg).Send("Не смог создать счёт: "+invoiceErr.Error()+"\nЗапрос не удалось вернуть в ожидание: "+err.Error(), menu)
+		}
+		return (*tlg).Send("Не смог создать счёт: "+invoiceErr.Error()+"\nЗапрос остался в ожидании, попробуй ещё раз позже", menu)
+	}
+
+	if _, err := app.moveTopUpRequest(ctx, id, company.Id, topUpApproving, topUpApproved, user.Id); err != nil {
+		app.log.WithField("topUpRequestId", id).Warn(err)
+	}
+	app.notifyRequester(ctx, request, "✅ Администратор одобрил пополнение баланса на "+FormatRubles(request.Amount)+" и создал счёт")
+	return invoiceErr
+}
+
+func (app *App) RejectTopUp(tlg *tele.Context) error {
+	ctx := UpdateContext(*tlg)
+	user, company, menu, err := ReadContext(*tlg)
+	if err != nil {
+		return (*tlg).Send(err.Error(), app.startMenu)
+	}
+	request, err := app.moveTopUpRequest(ctx, (*tlg).Data(), company.Id, topUpPending, topUpRejected, user.Id)
+	if err != nil {
+		return (*tlg).Send(err.Error(), menu)
+	}
+	app.notifyRequester(ctx, request, "❌ Администратор отклонил пополнение баланса на "+FormatRubles(request.Amount))
+	return (*tlg).Send("Отклонил запрос на "+FormatRubles(request.Amount), menu)
+}
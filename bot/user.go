@@ -0,0 +1,215 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"strconv"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+type User struct {
+	Id          int64
+	Phone       string
+	CompanyId   int
+	Admin       bool
+	LastBalance float64
+	// Set when the user has blocked the bot, cleared by /start
+	Inactive bool
+}
+
+func (user *User) Recipient() string {
+	return strconv.FormatInt(user.Id, 10)
+}
+
+func (app *App) SaveUser(ctx context.Context, user *User) error {
+	userLogger := app.log.WithField("userId", user.Id)
+	userLogger.Trace("Saving user...")
+	buf := bytes.Buffer{}
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(user); err != nil {
+		userLogger.Warn(err)
+		return err
+	}
+
+	userDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("users").Doc(strconv.FormatInt(user.Id, 10))
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	if _, err := userDocRef.Set(reqCtx, map[string]interface{}{"gob": base64.StdEncoding.EncodeToString(buf.Bytes())}); err != nil {
+		userLogger.Warn(err)
+		return err
+	}
+
+	userLogger.Info("Saved!")
+	return nil
+}
+
+func (app *App) LoadUser(ctx context.Context, id int64) (user *User, err error) {
+	userLogger := app.log.WithField("userId", id)
+	userLogger.Trace("Loading user data...")
+	userDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("users").Doc(strconv.FormatInt(id, 10))
+
+	reqCtx, cancel := app.storageContext(ctx)
+	userDoc, err := userDocRef.Get(reqCtx)
+	cancel()
+	if err != nil {
+		userLogger.Warn(err)
+		return nil, err
+	}
+
+	userGob, err := userDoc.DataAt("gob")
+	if err != nil {
+		userLogger.Warn(err)
+		return nil, err
+	}
+
+	by, err := base64.StdEncoding.DecodeString(userGob.(string))
+	if err != nil {
+		userLogger.Warn(err)
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	buf.Write(by)
+	dec := gob.NewDecoder(&buf)
+	err = dec.Decode(&user)
+	if err != nil {
+		userLogger.Warn(err)
+		return nil, err
+	}
+
+	userLogger.Info("Loaded!")
+	return user, nil
+}
+
+func (app *App) RemoveUser(ctx context.Context, id int64) error {
+	userLogger := app.log.WithField("userId", id)
+	userLogger.Trace("Removing user data...")
+	userDocRef := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("users").Doc(strconv.FormatInt(id, 10))
+	reqCtx, cancel := app.storageContext(ctx)
+	defer cancel()
+	if _, err := userDocRef.Delete(reqCtx); err != nil {
+		userLogger.Warn(err)
+		return err
+	}
+	userLogger.Info("Deleted!")
+	return nil
+}
+
+func (app *App) FindCompany(ctx context.Context, user *User) (company *Company, err error) {
+	userLogger := app.log.WithField("userId", user.Id)
+	userLogger.Trace("Looking for a company by user data...")
+	reqCtx, cancel := app.storageContext(ctx)
+	companyDocRefs, err := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("companies").DocumentRefs(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		userLogger.Warn(err)
+		return nil, err
+	}
+	for _, companyDocRef := range companyDocRefs {
+		companyId, err := strconv.Atoi(companyDocRef.ID)
+		if err != nil {
+			userLogger.Warn(err)
+			return nil, err
+		}
+		company, err = app.LoadCompany(ctx, companyId)
+		if errors.Is(err, ErrReauthRequired) {
+			continue
+		}
+		if err != nil {
+			userLogger.Warn(err)
+			return nil, err
+		}
+		if err = company.SetEmployees(); err != nil {
+			userLogger.Warn(err)
+			return nil, err
+		}
+		exist, err := company.HasEmployee(user.Phone)
+		if err != nil {
+			userLogger.Warn(err)
+			return nil, err
+		}
+		if exist {
+			user.CompanyId = company.Id
+			app.SetLastBalance(user, company)
+			if err = app.SaveUser(ctx, user); err != nil {
+				userLogger.Warn(err)
+				return nil, err
+			}
+			userLogger.Trace("Found user in a company!")
+			return company, nil
+		}
+	}
+
+	userLogger.Trace("Didn't find user in any company")
+	return nil, nil
+}
+
+func (app *App) SetLastBalance(user *User, company *Company) {
+	if err := company.SetInfo(); err != nil {
+		app.log.Warn(err)
+	}
+	user.LastBalance = company.Balance
+}
+
+// unreachable reports if messages can't be delivered to the user anymore, e.g. the bot is blocked.
+func unreachable(err error) bool {
+	return errors.Is(err, tele.ErrBlockedByUser) || errors.Is(err, tele.ErrChatNotFound) ||
+		errors.Is(err, tele.ErrUserIsDeactivated) || errors.Is(err, tele.ErrNotStartedByUser)
+}
+
+// DeactivateIfUnreachable stops notifying the user if the sending has failed because of the user.
+func (app *App) DeactivateIfUnreachable(ctx context.Context, userId int64, err error) bool {
+	if !unreachable(err) {
+		return false
+	}
+	user, loadErr := app.LoadUser(ctx, userId)
+	if loadErr != nil || user.Inactive {
+		return true
+	}
+	app.log.WithField("userId", userId).Warn("User is unreachable, stopping notifications: ", err)
+	user.Inactive = true
+	app.SaveUser(ctx, user)
+	return true
+}
+
+// Reactivate resumes notifications of the user who has blocked the bot before.
+func (app *App) Reactivate(ctx context.Context, userId int64) {
+	user, err := app.LoadUser(ctx, userId)
+	if err != nil || !user.Inactive {
+		return
+	}
+	app.log.WithField("userId", userId).Info("User is back, resuming notifications")
+	user.Inactive = false
+	app.SaveUser(ctx, user)
+}
+
+// LoadCompanyUsers returns users of the company who can be notified, inactive ones are left out.
+func (app *App) LoadCompanyUsers(ctx context.Context, companyId int) (users []*User, err error) {
+	companyLogger := app.log.WithField("companyId", companyId)
+	companyLogger.Trace("Loading company users...")
+	reqCtx, cancel := app.storageContext(ctx)
+	userDocRefs, err := app.client.Collection(app.config.ProjectID).Doc(app.config.Environment).Collection("users").DocumentRefs(reqCtx).GetAll()
+	cancel()
+	if err != nil {
+		companyLogger.Warn(err)
+		return nil, err
+	}
+	for _, userDocRef := range userDocRefs {
+		userId, err := strconv.ParseInt(userDocRef.ID, 10, 64)
+		if err != nil {
+			companyLogger.Warn(err)
+			continue
+		}
+		user, err := app.LoadUser(ctx, userId)
+		if err != nil {
+			continue
+		}
+		if user.CompanyId == companyId && !user.Inactive {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
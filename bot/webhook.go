@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+type WebhookConfig struct {
+	Listen      string `json:"listen"`
+	PublicURL   string `json:"public_url"`
+	SecretToken string `json:"secret_token"`
+	TLSCert     string `json:"tls_cert"`
+	TLSKey      string `json:"tls_key"`
+}
+
+// NewPoller chooses webhook mode if it is configured and long polling otherwise.
+// The client is used to register the webhook, as the one of the bot isn't exported.
+func NewPoller(config *WebhookConfig, client *http.Client, logger *logrus.Logger) tele.Poller {
+	if config == nil {
+		return &LongPoller{LongPoller: tele.LongPoller{Timeout: 10 * time.Second}, log: logger}
+	}
+	return &Webhook{config: config, client: client, log: logger}
+}
+
+// Registrar is a poller which has to be registered in Telegram before it's polled.
+type Registrar interface {
+	Register(b *tele.Bot) error
+}
+
+// LongPoller removes the webhook left from webhook mode, otherwise Telegram refuses to give updates.
+type LongPoller struct {
+	tele.LongPoller
+	log *logrus.Logger
+}
+
+func (p *LongPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	if err := b.RemoveWebhook(); err != nil {
+		p.log.Warn("Can't remove webhook: ", err)
+	}
+	p.LongPoller.Poll(b, dest, stop)
+}
+
+// Webhook receives updates on the configured address, usually behind a reverse proxy.
+// Unlike tele.Webhook it registers the secret token and rejects requests without it.
+// It should be registered before polling.
+type Webhook struct {
+	config *WebhookConfig
+	client *http.Client
+	log    *logrus.Logger
+	dest   chan<- tele.Update
+	stop   chan struct{}
+}
+
+// Register sets the webhook in Telegram. With tls_cert the certificate is uploaded too, so a self-signed one is trusted.
+func (h *Webhook) Register(b *tele.Bot) error {
+	h.log.Trace("Registering webhook...")
+	body := bytes.Buffer{}
+	w := multipart.NewWriter(&body)
+	w.WriteField("url", h.config.PublicURL)
+	if h.config.SecretToken != "" {
+		w.WriteField("secret_token", h.config.SecretToken)
+	}
+	if h.config.TLSCert != "" {
+		cert, err := os.ReadFile(h.config.TLSCert)
+		if err != nil {
+			return err
+		}
+		part, err := w.CreateFormFile("certificate", filepath.Base(h.config.TLSCert))
+		if err != nil {
+			return err
+		}
+		part.Write(cert)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(b.URL+"/bot"+b.Token+"/setWebhook", w.FormDataContentType(), &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Ok {
+		return errors.New("setWebhook: " + result.Description)
+	}
+	h.log.Info("Webhook registered.")
+	return nil
+}
+
+func (h *Webhook) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	h.dest = dest
+	h.stop = stop
+
+	s := &http.Server{Addr: h.config.Listen, Handler: h}
+	go func() {
+		var err error
+		if h.config.TLSCert != "" {
+			err = s.ListenAndServeTLS(h.config.TLSCert, h.config.TLSKey)
+		} else {
+			err = s.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			h.log.Error("Webhook server stopped: ", err)
+		}
+	}()
+
+	// The bot closes stop itself
+	<-stop
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	s.Shutdown(shutdownCtx)
+	h.log.Trace("Removing webhook...")
+	if err := b.RemoveWebhook(); err != nil {
+		h.log.Warn("Can't remove webhook: ", err)
+	}
+}
+
+func (h *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.config.SecretToken)) != 1 {
+		h.log.Warn("Webhook request with wrong secret token from ", r.RemoteAddr)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var update tele.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		h.log.Warn("Can't decode update: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Nobody reads updates after stop, Telegram delivers the update again later
+	select {
+	case h.dest <- update:
+	case <-h.stop:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
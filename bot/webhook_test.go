@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tele "gopkg.in/tucnak/telebot.v3"
+)
+
+func TestWebhookRegister(t *testing.T) {
+	var form map[string]string
+	ok := true
+	telegram := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		form = make(map[string]string)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+		}
+		for key, values := range r.MultipartForm.Value {
+			form[key] = values[0]
+		}
+		for key, files := range r.MultipartForm.File {
+			file, _ := files[0].Open()
+			data, _ := io.ReadAll(file)
+			form[key] = string(data)
+		}
+		if ok {
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		} else {
+			w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: bad webhook"}`))
+		}
+	}))
+	defer telegram.Close()
+	b, err := tele.NewBot(tele.Settings{URL: telegram.URL, Token: "token", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(cert, []byte("-----BEGIN CERTIFICATE-----"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	webhook := NewPoller(&WebhookConfig{PublicURL: "https://bot.example.com/hook", SecretToken: "secret", TLSCert: cert}, telegram.Client(), NewLogger("test")).(*Webhook)
+	if err := webhook.Register(b); err != nil {
+		t.Fatal(err)
+	}
+	if form["url"] != "https://bot.example.com/hook" || form["secret_token"] != "secret" || form["certificate"] != "-----BEGIN CERTIFICATE-----" {
+		t.Errorf("setWebhook form = %v", form)
+	}
+
+	ok = false
+	if err := webhook.Register(b); err == nil || !strings.Contains(err.Error(), "bad webhook") {
+		t.Errorf("err = %v, want the description of Telegram", err)
+	}
+}
+
+func TestWebhookAfterStop(t *testing.T) {
+	webhook := &Webhook{config: &WebhookConfig{SecretToken: "secret"}, log: NewLogger("test"), dest: make(chan tele.Update), stop: make(chan struct{})}
+	close(webhook.stop)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"update_id":1}`))
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "secret")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		webhook.ServeHTTP(w, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("update is stuck after stop")
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d so Telegram delivers the update again", w.Code, http.StatusServiceUnavailable)
+	}
+}